@@ -17,9 +17,12 @@
 package action
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/megamsys/libgo/cmd"
@@ -42,25 +45,49 @@ type Forward func(context FWContext) (Result, error)
 // phase.
 type Backward func(context BWContext)
 
+// FallibleBackward is a Backward function that reports whether the roll back
+// failed, leaving resources behind.
+type FallibleBackward func(context BWContext) error
+
 type OnErrorFunc func(FWContext, error)
 
 // FWContext is the context used in calls to Forward functions (forward phase).
 type FWContext struct {
-	// Result of the previous action.
+	// Context of the pipeline execution. Forward functions must give up
+	// when it is done, as the executor waits for them to return.
+	Context context.Context
+
+	// Result of the previous action. In a Graph, it is the result of the
+	// dependency when the action has exactly one.
 	Previous Result
 
+	// Results of the actions this action depends on, by name. Only set when
+	// running in a Graph.
+	Dependencies map[string]Result
+
 	// List of parameters given to the executor.
 	Params []interface{}
+
+	// Named parameters given to the pipeline, validated against its keys.
+	Values Values
 }
 
 // BWContext is the context used in calls to Backward functions (backward
 // phase).
 type BWContext struct {
+	// Context of the pipeline execution. It carries the values of the
+	// context given to the executor, but it is never cancelled, so the
+	// roll back can complete after an interruption.
+	Context context.Context
+
 	// Result of the forward phase (for the current action).
 	FWResult Result
 
 	// List of parameters given to the executor.
 	Params []interface{}
+
+	// Named parameters given to the pipeline.
+	Values Values
 }
 
 // Action defines actions that should be . It is composed of two functions:
@@ -80,13 +107,41 @@ type Action struct {
 	// that are not undoable, this attribute should be nil.
 	Backward Backward
 
+	// Function that will be invoked in the backward phase instead of
+	// Backward, for actions whose roll back may fail. Its errors are
+	// reported in a PipelineError.
+	FallibleBackward FallibleBackward
+
 	// Minimum number of parameters that this action requires to run.
 	MinParams int
 
+	// Names of the actions that must complete before this one runs. Only
+	// used when running in a Graph.
+	DependsOn []string
+
+	// Maximum time the Forward function is allowed to run, for each
+	// attempt. Zero means no limit other than the context given to the
+	// executor.
+	Timeout time.Duration
+
+	// Policy for retrying the Forward function when it fails. When nil, the
+	// first failure rolls the pipeline back.
+	Retry *RetryPolicy
+
 	// Function taht will be invoked after some failure occurured in the
-	// Forward phase of this same action.
+	// Forward phase of this same action. With a retry policy, it is invoked
+	// only after the last attempt.
 	OnError OnErrorFunc
 
+	// Function that describes what the Forward function would do, used by
+	// Pipeline.Plan. Optional.
+	Plan Planner
+
+	// Function that restores the Result of the Forward function from its
+	// JSON representation in the pipeline journal. When nil, the JSON is
+	// decoded into an interface{}.
+	DecodeResult func(data []byte) (Result, error)
+
 	// Result of the action. Stored for use in the backward phase.
 	result Result
 
@@ -99,6 +154,22 @@ type Action struct {
 // that all actions are really small and atomic.
 type Pipeline struct {
 	actions []*Action
+
+	// Identifier of the pipeline in the journal.
+	id string
+
+	// Journal where the execution is recorded. Optional.
+	journal JournalStore
+
+	// Sequence number of the next journal entry.
+	seq int
+
+	// Observers attached to the pipeline.
+	observers []PipelineObserver
+
+	// Registered parameters, and their values.
+	keys   []*Key
+	values Values
 }
 
 // NewPipeline creates a new pipeline instance with the given list of actions.
@@ -113,6 +184,71 @@ func (p *Pipeline) Result() Result {
 	return action.result
 }
 
+// InterruptedError is returned by the executor when a step is interrupted,
+// either because the context was cancelled or because the deadline of the
+// context or the Timeout of the action expired.
+type InterruptedError struct {
+	// Index of the step that was interrupted.
+	Step int
+
+	// Name of the action that was interrupted.
+	Action string
+
+	// The context error (context.Canceled or context.DeadlineExceeded).
+	Err error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("step %d: %s action interrupted: %s", e.Step, e.Action, e.Err)
+}
+
+// RollbackError is the failure of the backward phase of an action.
+type RollbackError struct {
+	// Index of the action that could not be rolled back.
+	Step int
+
+	// Name of the action that could not be rolled back.
+	Action string
+
+	// Error returned by the FallibleBackward function.
+	Err error
+}
+
+// PipelineError is returned by the executor when an action fails and the roll
+// back of some of the completed actions fails too, so it is possible to tell
+// which resources were left behind. When every roll back succeeds, the
+// executor returns the original error instead.
+type PipelineError struct {
+	// Index of the action that failed.
+	Step int
+
+	// Name of the action that failed.
+	Action string
+
+	// Error returned by the action that failed.
+	Cause error
+
+	// Errors of the backward phase, in the order they happened.
+	Rollback []RollbackError
+}
+
+func (e *PipelineError) Error() string {
+	msgs := make([]string, len(e.Rollback))
+	for i, r := range e.Rollback {
+		msgs[i] = fmt.Sprintf("step %d: %s action: %s", r.Step, r.Action, r.Err)
+	}
+	msg := fmt.Sprintf("rollback failed (%s)", strings.Join(msgs, "; "))
+	if e.Cause == nil {
+		return msg
+	}
+	return fmt.Sprintf("%s; %s", e.Cause, msg)
+}
+
+// Unwrap returns the error of the action that failed.
+func (e *PipelineError) Unwrap() error {
+	return e.Cause
+}
+
 // Execute executes the pipeline.
 //
 // The execution starts in the forward phase, calling the Forward function of
@@ -124,52 +260,158 @@ func (p *Pipeline) Result() Result {
 // does not call the Backward function of the action that has failed.
 //
 // After rolling back all completed actions, it returns the original error
-// returned by the action that failed.
+// returned by the action that failed, or a *PipelineError when some of the
+// roll backs failed.
 func (p *Pipeline) Execute(params ...interface{}) error {
-	var (
-		r   Result
-		err error
-	)
+	return p.ExecuteContext(context.Background(), params...)
+}
+
+// ExecuteContext executes the pipeline like Execute, bounded by the given
+// context.
+//
+// When the context is done, or when an action runs for longer than its
+// Timeout, the context given to the current Forward function is done too.
+// Once it returns, the executor switches to the backward phase, returning an
+// *InterruptedError that names the step. The interrupted action is rolled
+// back only when its Forward function completed anyway.
+func (p *Pipeline) ExecuteContext(ctx context.Context, params ...interface{}) error {
 	if len(p.actions) == 0 {
 		return errors.New("No actions to execute.")
 	}
+	if err := validateValues(p.keys, p.values); err != nil {
+		return err
+	}
+	e := newExecution(ctx, p.id, len(p.actions), p.observers)
+	e.start()
+	if err := p.record(JournalStart, 0, nil); err != nil {
+		return e.finish(err)
+	}
+	return e.finish(p.execute(e, 0, nil, params))
+}
 
+// execute runs the forward phase from the given step, rolling back on
+// failure.
+func (p *Pipeline) execute(e *Execution, start int, previous Result, params []interface{}) error {
+	var (
+		r   Result
+		err error
+	)
+	ctx := e.Context
 	log.Debugf(cmd.Colorfy(fmt.Sprintf("==> pipeline [%d]", len(p.actions)), "white", "", "bold"))
 
-	fwCtx := FWContext{Params: params}
-	for i, a := range p.actions {
+	fwCtx := FWContext{Context: ctx, Previous: previous, Params: params, Values: p.values}
+	for i := start; i < len(p.actions); i++ {
+		a := p.actions[i]
+		// index of the last action to roll back on failure
+		undo := i - 1
 		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action", i, a.Name), "green", "", "bold"))
+		e.stepStart(i, a)
+		begin := time.Now()
 		if a.Forward == nil {
 			err = errors.New("All actions must define the forward function.")
 		} else if len(fwCtx.Params) < a.MinParams {
 			err = errors.New("Not enough parameters to call Action.Forward.")
-		} else {
-			r, err = a.Forward(fwCtx)
+		} else if ctx.Err() != nil {
+			err = &InterruptedError{Step: i, Action: a.Name, Err: ctx.Err()}
+		} else if err = p.record(JournalStepStart, i, nil); err == nil {
+			r, err = a.run(i, fwCtx)
 			a.rMutex.Lock()
 			a.result = r
 			a.rMutex.Unlock()
 			fwCtx.Previous = r
+			if err == nil {
+				undo = i
+				err = p.record(JournalStepDone, i, r)
+			}
 		}
+		e.stepEnd(i, a, time.Since(begin), err)
 		if err != nil {
 			log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action error - %s", i, a.Name, err), "yellow", "", ""))
 			if a.OnError != nil {
 				a.OnError(fwCtx, err)
 			}
-			p.rollback(i-1, params)
+			if errs := p.rollback(e, undo, params); len(errs) > 0 {
+				return &PipelineError{Step: i, Action: a.Name, Cause: err, Rollback: errs}
+			}
 			return err
 		}
 	}
+	if err = p.record(JournalCommit, 0, nil); err != nil {
+		return err
+	}
 	log.Debugf(cmd.Colorfy("==> pipeline /-end-/", "white", "", "bold"))
 	return nil
 }
 
-func (p *Pipeline) rollback(index int, params []interface{}) {
-	bwCtx := BWContext{Params: params}
+// forward calls the Forward function of the action with a context that is
+// done when the pipeline one is, or when the Timeout of the action expires.
+// Forward functions must return soon after it is done. One that completes
+// anyway is rolled back, as the pipeline gave up on it.
+func (a *Action) forward(step int, fwCtx FWContext) (Result, error) {
+	ctx := fwCtx.Context
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	fwCtx.Context = ctx
+	r, err := a.Forward(fwCtx)
+	if ctx.Err() == nil {
+		return r, err
+	}
+	if err == nil {
+		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action completed after interruption", step, a.Name), "yellow", "", ""))
+		a.backward(step, BWContext{Context: detachedContext{ctx}, FWResult: r, Params: fwCtx.Params, Values: fwCtx.Values})
+	}
+	return nil, &InterruptedError{Step: step, Action: a.Name, Err: ctx.Err()}
+}
+
+// detachedContext keeps the values of its parent, but is never done.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// backward rolls the action back, returning the error of its
+// FallibleBackward function.
+func (a *Action) backward(step int, bwCtx BWContext) error {
+	var err error
+	if a.FallibleBackward != nil {
+		err = a.FallibleBackward(bwCtx)
+	} else if a.Backward != nil {
+		a.Backward(bwCtx)
+	}
+	if err != nil {
+		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action rollback error - %s", step, a.Name, err), "yellow", "", ""))
+	}
+	return err
+}
+
+// rollback rolls back the actions up to the given index, in reverse order,
+// returning the errors of the ones that failed.
+func (p *Pipeline) rollback(e *Execution, index int, params []interface{}) []RollbackError {
+	var errs []RollbackError
+	bwCtx := BWContext{Context: detachedContext{e.Context}, Params: params, Values: p.values}
 	for i := index; i >= 0; i-- {
-		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action", i, p.actions[i].Name), "red", "", "bold"))
-		if p.actions[i].Backward != nil {
-			bwCtx.FWResult = p.actions[i].result
-			p.actions[i].Backward(bwCtx)
+		a := p.actions[i]
+		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action", i, a.Name), "red", "", "bold"))
+		a.rMutex.Lock()
+		bwCtx.FWResult = a.result
+		a.rMutex.Unlock()
+		err := a.backward(i, bwCtx)
+		if err != nil {
+			errs = append(errs, RollbackError{Step: i, Action: a.Name, Err: err})
 		}
+		e.rollbackStep(i, a, err)
+		if err := p.recordRollback(i, err); err != nil {
+			log.Warningf("  => step %d: %s action rollback not journaled - %s", i, a.Name, err)
+		}
+	}
+	if err := p.record(JournalAbort, 0, nil); err != nil {
+		log.Warningf("  => pipeline %s abort not journaled - %s", p.id, err)
 	}
+	return errs
 }
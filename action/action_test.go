@@ -17,8 +17,10 @@
 package action
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"gopkg.in/check.v1"
 )
@@ -188,3 +190,213 @@ func (s *S) TestActionOnError(c *check.C) {
 	c.Assert(err, check.Equals, returnedErr)
 	c.Assert(called, check.Equals, true)
 }
+
+func (s *S) TestExecuteContextPassesContext(c *check.C) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	var fwValue, bwValue interface{}
+	actions := []*Action{
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				fwValue = ctx.Context.Value(key{})
+				return "ok", nil
+			},
+			Backward: func(ctx BWContext) {
+				bwValue = ctx.Context.Value(key{})
+			},
+		},
+		&errorAction,
+	}
+	pipeline := NewPipeline(actions...)
+	err := pipeline.ExecuteContext(ctx)
+	c.Assert(err, check.NotNil)
+	c.Assert(fwValue, check.Equals, "value")
+	c.Assert(bwValue, check.Equals, "value")
+}
+
+func (s *S) TestExecuteContextCancelled(c *check.C) {
+	var executed bool
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	actions := []*Action{
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				return "ok", nil
+			},
+			Backward: func(ctx BWContext) {
+				c.Assert(ctx.Context.Err(), check.IsNil)
+				executed = true
+			},
+		},
+		{
+			Name: "cancelled",
+			Forward: func(ctx FWContext) (Result, error) {
+				cancel()
+				select {
+				case <-release:
+				case <-ctx.Context.Done():
+				}
+				return nil, ctx.Context.Err()
+			},
+		},
+	}
+	pipeline := NewPipeline(actions...)
+	err := pipeline.ExecuteContext(ctx)
+	c.Assert(err, check.DeepEquals, &InterruptedError{Step: 1, Action: "cancelled", Err: context.Canceled})
+	c.Assert(executed, check.Equals, true)
+}
+
+func (s *S) TestExecuteContextCompletedAfterCancel(c *check.C) {
+	var rolledBack []Result
+	ctx, cancel := context.WithCancel(context.Background())
+	actions := []*Action{
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				return "ok", nil
+			},
+			Backward: func(ctx BWContext) {
+				rolledBack = append(rolledBack, ctx.FWResult)
+			},
+		},
+		{
+			Name: "late",
+			Forward: func(ctx FWContext) (Result, error) {
+				cancel()
+				return "late", nil
+			},
+			Backward: func(ctx BWContext) {
+				c.Assert(ctx.Context.Err(), check.IsNil)
+				rolledBack = append(rolledBack, ctx.FWResult)
+			},
+		},
+	}
+	pipeline := NewPipeline(actions...)
+	err := pipeline.ExecuteContext(ctx)
+	c.Assert(err, check.DeepEquals, &InterruptedError{Step: 1, Action: "late", Err: context.Canceled})
+	c.Assert(rolledBack, check.DeepEquals, []Result{"late", "ok"})
+}
+
+func (s *S) TestExecuteContextAlreadyCancelled(c *check.C) {
+	var called bool
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	myAction := Action{
+		Name: "never",
+		Forward: func(ctx FWContext) (Result, error) {
+			called = true
+			return nil, nil
+		},
+	}
+	pipeline := NewPipeline(&myAction)
+	err := pipeline.ExecuteContext(ctx)
+	c.Assert(err, check.DeepEquals, &InterruptedError{Step: 0, Action: "never", Err: context.Canceled})
+	c.Assert(called, check.Equals, false)
+}
+
+func (s *S) TestExecuteContextActionTimeout(c *check.C) {
+	var executed bool
+	release := make(chan struct{})
+	defer close(release)
+	actions := []*Action{
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				return "ok", nil
+			},
+			Backward: func(ctx BWContext) {
+				executed = true
+			},
+		},
+		{
+			Name:    "hung",
+			Timeout: 10 * time.Millisecond,
+			Forward: func(ctx FWContext) (Result, error) {
+				select {
+				case <-release:
+				case <-ctx.Context.Done():
+				}
+				return nil, ctx.Context.Err()
+			},
+		},
+	}
+	pipeline := NewPipeline(actions...)
+	err := pipeline.ExecuteContext(context.Background())
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*InterruptedError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Step, check.Equals, 1)
+	c.Assert(e.Action, check.Equals, "hung")
+	c.Assert(e.Err, check.Equals, context.DeadlineExceeded)
+	c.Assert(e.Error(), check.Equals, "step 1: hung action interrupted: context deadline exceeded")
+	c.Assert(executed, check.Equals, true)
+	c.Assert(pipeline.Result(), check.IsNil)
+}
+
+func (s *S) TestRollbackErrors(c *check.C) {
+	var rolledBack []string
+	diskErr := errors.New("disk is busy")
+	ipErr := errors.New("ip is locked")
+	actions := []*Action{
+		{
+			Name: "disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				return "disk-1", nil
+			},
+			FallibleBackward: func(ctx BWContext) error {
+				rolledBack = append(rolledBack, "disk")
+				return diskErr
+			},
+		},
+		{
+			Name: "network",
+			Forward: func(ctx FWContext) (Result, error) {
+				return "net-1", nil
+			},
+			Backward: func(ctx BWContext) {
+				rolledBack = append(rolledBack, "network")
+			},
+		},
+		{
+			Name: "ip",
+			Forward: func(ctx FWContext) (Result, error) {
+				return "ip-1", nil
+			},
+			FallibleBackward: func(ctx BWContext) error {
+				c.Assert(ctx.FWResult, check.Equals, "ip-1")
+				rolledBack = append(rolledBack, "ip")
+				return ipErr
+			},
+		},
+		&errorAction,
+	}
+	pipeline := NewPipeline(actions...)
+	err := pipeline.Execute()
+	c.Assert(rolledBack, check.DeepEquals, []string{"ip", "network", "disk"})
+	perr, ok := err.(*PipelineError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(perr.Step, check.Equals, 3)
+	c.Assert(perr.Action, check.Equals, "error")
+	c.Assert(perr.Cause, check.ErrorMatches, "Failed to execute.")
+	c.Assert(perr.Unwrap(), check.Equals, perr.Cause)
+	c.Assert(perr.Rollback, check.DeepEquals, []RollbackError{
+		{Step: 2, Action: "ip", Err: ipErr},
+		{Step: 0, Action: "disk", Err: diskErr},
+	})
+	c.Assert(err.Error(), check.Equals, "Failed to execute.; rollback failed (step 2: ip action: ip is locked; step 0: disk action: disk is busy)")
+}
+
+func (s *S) TestRollbackWithoutErrorsReturnsCause(c *check.C) {
+	myAction := Action{
+		Forward: func(ctx FWContext) (Result, error) {
+			return "ok", nil
+		},
+		FallibleBackward: func(ctx BWContext) error {
+			return nil
+		},
+	}
+	pipeline := NewPipeline(&myAction, &errorAction)
+	err := pipeline.Execute()
+	_, ok := err.(*PipelineError)
+	c.Assert(ok, check.Equals, false)
+	c.Assert(err.Error(), check.Equals, "Failed to execute.")
+}
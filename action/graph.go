@@ -0,0 +1,265 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/libgo/cmd"
+)
+
+// Graph is a set of actions with named dependencies between them, declared
+// by Action.DependsOn. Actions whose dependencies have completed run
+// concurrently, and each one receives the results of its dependencies in
+// FWContext.Dependencies.
+//
+// Like a Pipeline, a graph is atomic: when an action fails, no other action
+// is started, the running ones are waited for, and every completed action is
+// rolled back in reverse topological order. Roll back failures are reported
+// in a *PipelineError, whose steps are indexes in the list of actions.
+type Graph struct {
+	actions []*Action
+
+	// Index of each action by name.
+	index map[string]int
+
+	// Indexes of the actions that depend on each action.
+	dependents [][]int
+
+	// Topological order of the actions.
+	order []int
+
+	// Observers attached to the graph.
+	observers []PipelineObserver
+
+	// Registered parameters, and their values.
+	keys   []*Key
+	values Values
+}
+
+// NewGraph creates a new graph with the given actions. Every action must have
+// a unique name, and dependencies must name actions of the graph without
+// forming cycles.
+func NewGraph(actions ...*Action) (*Graph, error) {
+	g := &Graph{
+		actions:    actions,
+		index:      make(map[string]int, len(actions)),
+		dependents: make([][]int, len(actions)),
+	}
+	for i, a := range actions {
+		if a.Name == "" {
+			return nil, errors.New("All actions of a graph must have a name.")
+		}
+		if _, ok := g.index[a.Name]; ok {
+			return nil, fmt.Errorf("Duplicated action %q in the graph.", a.Name)
+		}
+		g.index[a.Name] = i
+	}
+	pending := make([]int, len(actions))
+	for i, a := range actions {
+		for _, name := range a.DependsOn {
+			j, ok := g.index[name]
+			if !ok {
+				return nil, fmt.Errorf("Action %q depends on unknown action %q.", a.Name, name)
+			}
+			pending[i]++
+			g.dependents[j] = append(g.dependents[j], i)
+		}
+	}
+	var ready []int
+	for i := range actions {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		g.order = append(g.order, i)
+		for _, j := range g.dependents[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if len(g.order) != len(actions) {
+		return nil, errors.New("Dependencies between actions form a cycle.")
+	}
+	return g, nil
+}
+
+// Result returns the result of the named action.
+func (g *Graph) Result(name string) Result {
+	i, ok := g.index[name]
+	if !ok {
+		return nil
+	}
+	action := g.actions[i]
+	action.rMutex.Lock()
+	defer action.rMutex.Unlock()
+	return action.result
+}
+
+// Execute executes the graph.
+//
+// If any of the Forward calls fails, it rolls back the completed actions and
+// returns the original error returned by the action that failed first.
+func (g *Graph) Execute(params ...interface{}) error {
+	return g.ExecuteContext(context.Background(), params...)
+}
+
+// ExecuteContext executes the graph like Execute, bounded by the given
+// context. When an action fails, the context given to the running ones is
+// cancelled, so they give up before the roll back.
+func (g *Graph) ExecuteContext(ctx context.Context, params ...interface{}) error {
+	type outcome struct {
+		node  int
+		r     Result
+		err   error
+		d     time.Duration
+		fwCtx FWContext
+	}
+	if len(g.actions) == 0 {
+		return errors.New("No actions to execute.")
+	}
+	if err := validateValues(g.keys, g.values); err != nil {
+		return err
+	}
+
+	log.Debugf(cmd.Colorfy(fmt.Sprintf("==> graph [%d]", len(g.actions)), "white", "", "bold"))
+
+	e := newExecution(ctx, "", len(g.actions), g.observers)
+	e.start()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		failure   error
+		failed    int
+		running   int
+		pending   = make([]int, len(g.actions))
+		completed = make([]bool, len(g.actions))
+		done      = make(chan outcome)
+	)
+	start := func(i int) {
+		a := g.actions[i]
+		fwCtx := FWContext{
+			Context:      ctx,
+			Params:       params,
+			Values:       g.values,
+			Dependencies: make(map[string]Result, len(a.DependsOn)),
+		}
+		for _, name := range a.DependsOn {
+			fwCtx.Dependencies[name] = g.Result(name)
+		}
+		if len(a.DependsOn) == 1 {
+			fwCtx.Previous = fwCtx.Dependencies[a.DependsOn[0]]
+		}
+		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action", i, a.Name), "green", "", "bold"))
+		e.stepStart(i, a)
+		running++
+		go func() {
+			begin := time.Now()
+			r, err := g.run(i, fwCtx)
+			done <- outcome{node: i, r: r, err: err, d: time.Since(begin), fwCtx: fwCtx}
+		}()
+	}
+	for _, i := range g.order {
+		pending[i] = len(g.actions[i].DependsOn)
+		if pending[i] == 0 {
+			start(i)
+		}
+	}
+	for running > 0 {
+		o := <-done
+		running--
+		a := g.actions[o.node]
+		a.rMutex.Lock()
+		a.result = o.r
+		a.rMutex.Unlock()
+		e.stepEnd(o.node, a, o.d, o.err)
+		if o.err != nil {
+			log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action error - %s", o.node, a.Name, o.err), "yellow", "", ""))
+			if a.OnError != nil {
+				a.OnError(o.fwCtx, o.err)
+			}
+			if failure == nil {
+				failure = o.err
+				failed = o.node
+				cancel()
+			}
+			continue
+		}
+		completed[o.node] = true
+		if failure != nil {
+			continue
+		}
+		for _, j := range g.dependents[o.node] {
+			pending[j]--
+			if pending[j] == 0 {
+				start(j)
+			}
+		}
+	}
+	if failure != nil {
+		if errs := g.rollback(e, completed, params); len(errs) > 0 {
+			return e.finish(&PipelineError{Step: failed, Action: g.actions[failed].Name, Cause: failure, Rollback: errs})
+		}
+		return e.finish(failure)
+	}
+	log.Debugf(cmd.Colorfy("==> graph /-end-/", "white", "", "bold"))
+	return e.finish(nil)
+}
+
+func (g *Graph) run(i int, fwCtx FWContext) (Result, error) {
+	a := g.actions[i]
+	if a.Forward == nil {
+		return nil, errors.New("All actions must define the forward function.")
+	}
+	if len(fwCtx.Params) < a.MinParams {
+		return nil, errors.New("Not enough parameters to call Action.Forward.")
+	}
+	if err := fwCtx.Context.Err(); err != nil {
+		return nil, &InterruptedError{Step: i, Action: a.Name, Err: err}
+	}
+	return a.run(i, fwCtx)
+}
+
+func (g *Graph) rollback(e *Execution, completed []bool, params []interface{}) []RollbackError {
+	var errs []RollbackError
+	bwCtx := BWContext{Context: detachedContext{e.Context}, Params: params, Values: g.values}
+	for k := len(g.order) - 1; k >= 0; k-- {
+		i := g.order[k]
+		if !completed[i] {
+			continue
+		}
+		a := g.actions[i]
+		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action", i, a.Name), "red", "", "bold"))
+		bwCtx.FWResult = g.Result(a.Name)
+		err := a.backward(i, bwCtx)
+		if err != nil {
+			errs = append(errs, RollbackError{Step: i, Action: a.Name, Err: err})
+		}
+		e.rollbackStep(i, a, err)
+	}
+	return errs
+}
@@ -0,0 +1,236 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// bootGraph builds the "create disk and reserve IP in parallel, then boot"
+// graph, recording the rolled back actions.
+func bootGraph(c *check.C, bootErr error, rolledBack *[]string) *Graph {
+	var mu sync.Mutex
+	started := make(chan string, 2)
+	both := make(chan struct{})
+	go func() {
+		<-started
+		<-started
+		close(both)
+	}()
+	parallel := func(name string) Forward {
+		return func(ctx FWContext) (Result, error) {
+			started <- name
+			select {
+			case <-both:
+			case <-time.After(time.Second):
+				return nil, errors.New(name + " did not run concurrently")
+			}
+			return name + "-1", nil
+		}
+	}
+	backward := func(ctx BWContext) {
+		mu.Lock()
+		defer mu.Unlock()
+		*rolledBack = append(*rolledBack, ctx.FWResult.(string))
+	}
+	g, err := NewGraph(
+		&Action{Name: "disk", Forward: parallel("disk"), Backward: backward},
+		&Action{Name: "ip", Forward: parallel("ip"), Backward: backward},
+		&Action{
+			Name:      "boot",
+			DependsOn: []string{"disk", "ip"},
+			Forward: func(ctx FWContext) (Result, error) {
+				c.Assert(ctx.Dependencies, check.DeepEquals, map[string]Result{"disk": "disk-1", "ip": "ip-1"})
+				c.Assert(ctx.Previous, check.IsNil)
+				return "vm-1", bootErr
+			},
+			Backward: backward,
+		},
+	)
+	c.Assert(err, check.IsNil)
+	return g
+}
+
+func (s *S) TestGraphExecute(c *check.C) {
+	var rolledBack []string
+	g := bootGraph(c, nil, &rolledBack)
+	err := g.Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Result("boot"), check.Equals, "vm-1")
+	c.Assert(g.Result("disk"), check.Equals, "disk-1")
+	c.Assert(rolledBack, check.IsNil)
+}
+
+func (s *S) TestGraphRollbackReverseTopologicalOrder(c *check.C) {
+	var rolledBack []string
+	bootErr := errors.New("boot failed")
+	g := bootGraph(c, bootErr, &rolledBack)
+	err := g.Execute()
+	c.Assert(err, check.Equals, bootErr)
+	c.Assert(rolledBack, check.DeepEquals, []string{"ip-1", "disk-1"})
+}
+
+func (s *S) TestGraphRollbackOnlyCompleted(c *check.C) {
+	var rolledBack []string
+	var booted, onError bool
+	ipErr := errors.New("no IP left")
+	disk := make(chan struct{})
+	g, err := NewGraph(
+		&Action{
+			Name: "disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				close(disk)
+				return "disk-1", nil
+			},
+			Backward: func(ctx BWContext) {
+				rolledBack = append(rolledBack, ctx.FWResult.(string))
+			},
+		},
+		&Action{
+			Name: "ip",
+			Forward: func(ctx FWContext) (Result, error) {
+				<-disk
+				return nil, ipErr
+			},
+			Backward: func(ctx BWContext) {
+				c.Fatal("failed action should not be rolled back")
+			},
+			OnError: func(ctx FWContext, err error) {
+				onError = true
+			},
+		},
+		&Action{
+			Name:      "boot",
+			DependsOn: []string{"disk", "ip"},
+			Forward: func(ctx FWContext) (Result, error) {
+				booted = true
+				return nil, nil
+			},
+		},
+	)
+	c.Assert(err, check.IsNil)
+	err = g.Execute()
+	c.Assert(err, check.Equals, ipErr)
+	c.Assert(booted, check.Equals, false)
+	c.Assert(onError, check.Equals, true)
+	c.Assert(rolledBack, check.DeepEquals, []string{"disk-1"})
+}
+
+func (s *S) TestGraphCancelsSiblingsOnFailure(c *check.C) {
+	ipErr := errors.New("no IP left")
+	var interrupted bool
+	started := make(chan struct{})
+	g, err := NewGraph(
+		&Action{
+			Name: "disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				close(started)
+				<-ctx.Context.Done()
+				interrupted = true
+				return nil, ctx.Context.Err()
+			},
+		},
+		&Action{
+			Name: "ip",
+			Forward: func(ctx FWContext) (Result, error) {
+				<-started
+				return nil, ipErr
+			},
+		},
+	)
+	c.Assert(err, check.IsNil)
+	err = g.Execute()
+	c.Assert(err, check.Equals, ipErr)
+	c.Assert(interrupted, check.Equals, true)
+}
+
+func (s *S) TestGraphPreviousWithSingleDependency(c *check.C) {
+	g, err := NewGraph(
+		&Action{
+			Name:      "second",
+			DependsOn: []string{"first"},
+			Forward: func(ctx FWContext) (Result, error) {
+				return ctx.Previous, nil
+			},
+		},
+		&Action{
+			Name: "first",
+			Forward: func(ctx FWContext) (Result, error) {
+				return "ok", nil
+			},
+		},
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Execute(), check.IsNil)
+	c.Assert(g.Result("second"), check.Equals, "ok")
+}
+
+func (s *S) TestNewGraphErrors(c *check.C) {
+	forward := func(ctx FWContext) (Result, error) { return nil, nil }
+	_, err := NewGraph(&Action{Forward: forward})
+	c.Assert(err, check.ErrorMatches, "All actions of a graph must have a name.")
+	_, err = NewGraph(&Action{Name: "a", Forward: forward}, &Action{Name: "a", Forward: forward})
+	c.Assert(err, check.ErrorMatches, `Duplicated action "a" in the graph.`)
+	_, err = NewGraph(&Action{Name: "a", DependsOn: []string{"b"}, Forward: forward})
+	c.Assert(err, check.ErrorMatches, `Action "a" depends on unknown action "b".`)
+	_, err = NewGraph(
+		&Action{Name: "a", DependsOn: []string{"b"}, Forward: forward},
+		&Action{Name: "b", DependsOn: []string{"a"}, Forward: forward},
+	)
+	c.Assert(err, check.ErrorMatches, "Dependencies between actions form a cycle.")
+}
+
+func (s *S) TestGraphExecuteNoActions(c *check.C) {
+	g, err := NewGraph()
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Execute(), check.ErrorMatches, "No actions to execute.")
+}
+
+func (s *S) TestGraphRollbackErrors(c *check.C) {
+	diskErr := errors.New("disk is busy")
+	bootErr := errors.New("boot failed")
+	g, err := NewGraph(
+		&Action{
+			Name: "disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				return "disk-1", nil
+			},
+			FallibleBackward: func(ctx BWContext) error {
+				return diskErr
+			},
+		},
+		&Action{
+			Name:      "boot",
+			DependsOn: []string{"disk"},
+			Forward: func(ctx FWContext) (Result, error) {
+				return nil, bootErr
+			},
+		},
+	)
+	c.Assert(err, check.IsNil)
+	err = g.Execute()
+	c.Assert(err, check.DeepEquals, &PipelineError{
+		Step:     1,
+		Action:   "boot",
+		Cause:    bootErr,
+		Rollback: []RollbackError{{Step: 0, Action: "disk", Err: diskErr}},
+	})
+}
@@ -0,0 +1,382 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megamsys/libgo/fs"
+)
+
+// JournalEvent is the kind of a journal entry.
+type JournalEvent string
+
+const (
+	// The pipeline started its forward phase.
+	JournalStart JournalEvent = "start"
+
+	// An action is about to run its Forward function.
+	JournalStepStart JournalEvent = "step-start"
+
+	// The Forward function of an action completed. The entry carries its
+	// serialized Result.
+	JournalStepDone JournalEvent = "step-done"
+
+	// An action was rolled back.
+	JournalRollback JournalEvent = "rollback"
+
+	// The pipeline was committed.
+	JournalCommit JournalEvent = "commit"
+
+	// The pipeline was rolled back.
+	JournalAbort JournalEvent = "abort"
+)
+
+var (
+	ErrNoJournal        = errors.New("Pipeline has no journal.")
+	ErrJournalNotFound  = errors.New("Pipeline not found in the journal.")
+	ErrPipelineFinished = errors.New("Pipeline already finished.")
+	ErrRollingBack      = errors.New("Pipeline was rolling back, it cannot be resumed.")
+)
+
+// JournalEntry is a record of the pipeline journal.
+type JournalEntry struct {
+	// Identifier of the pipeline.
+	Pipeline string `json:"pipeline"`
+
+	// Sequence number of the entry within the pipeline.
+	Seq int `json:"seq"`
+
+	Event JournalEvent `json:"event"`
+
+	// Index and name of the action, for step events.
+	Step   int    `json:"step"`
+	Action string `json:"action,omitempty"`
+
+	// JSON representation of the Result, for JournalStepDone entries.
+	Result json.RawMessage `json:"result,omitempty"`
+
+	// Error of the roll back, for JournalRollback entries of actions that
+	// could not be rolled back.
+	Error string `json:"error,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// JournalStore is the storage behind a pipeline journal.
+type JournalStore interface {
+	// Append adds an entry to the journal of its pipeline.
+	Append(entry JournalEntry) error
+
+	// Entries returns all the entries of the given pipeline.
+	Entries(pipeline string) ([]JournalEntry, error)
+}
+
+// WithJournal records the execution of the pipeline under the given
+// identifier, so that it can be resumed or rolled back by a later process
+// after an interruption. It returns the pipeline itself.
+//
+// The entries already in the journal under the identifier are kept, the
+// new ones are numbered after them.
+func (p *Pipeline) WithJournal(id string, store JournalStore) *Pipeline {
+	p.id = id
+	p.journal = store
+	p.seq = -1
+	return p
+}
+
+func (p *Pipeline) entry(event JournalEvent, step int) JournalEntry {
+	entry := JournalEntry{Pipeline: p.id, Seq: p.seq, Event: event, Step: step, Time: time.Now()}
+	if event != JournalStart && event != JournalCommit && event != JournalAbort {
+		entry.Action = p.actions[step].Name
+	}
+	return entry
+}
+
+// loadSeq sets the sequence number of the next entry after the last one of
+// the journal, unless it is already known.
+func (p *Pipeline) loadSeq() error {
+	if p.seq >= 0 {
+		return nil
+	}
+	entries, err := p.journal.Entries(p.id)
+	if err != nil {
+		return err
+	}
+	p.seq = 0
+	for _, e := range entries {
+		if e.Seq >= p.seq {
+			p.seq = e.Seq + 1
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) append(entry JournalEntry) error {
+	if err := p.journal.Append(entry); err != nil {
+		return err
+	}
+	p.seq++
+	return nil
+}
+
+func (p *Pipeline) record(event JournalEvent, step int, r Result) error {
+	if p.journal == nil {
+		return nil
+	}
+	if err := p.loadSeq(); err != nil {
+		return err
+	}
+	entry := p.entry(event, step)
+	if r != nil {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		entry.Result = data
+	}
+	return p.append(entry)
+}
+
+func (p *Pipeline) recordRollback(step int, failure error) error {
+	if p.journal == nil {
+		return nil
+	}
+	if err := p.loadSeq(); err != nil {
+		return err
+	}
+	entry := p.entry(JournalRollback, step)
+	if failure != nil {
+		entry.Error = failure.Error()
+	}
+	return p.append(entry)
+}
+
+// Resume continues the forward phase of a journaled pipeline that was
+// interrupted, for instance because its process died. The pipeline must be
+// built with the same actions, in the same order, as the interrupted one.
+//
+// The actions recorded as completed are skipped and their results restored,
+// so the next action receives the right Previous result. An action that
+// started but did not complete is executed again.
+func (p *Pipeline) Resume(params ...interface{}) error {
+	return p.ResumeContext(context.Background(), params...)
+}
+
+// ResumeContext resumes the pipeline like Resume, bounded by the given
+// context.
+func (p *Pipeline) ResumeContext(ctx context.Context, params ...interface{}) error {
+	if err := validateValues(p.keys, p.values); err != nil {
+		return err
+	}
+	next, previous, rollingBack, err := p.restore()
+	if err != nil {
+		return err
+	}
+	if rollingBack {
+		return ErrRollingBack
+	}
+	e := newExecution(ctx, p.id, len(p.actions), p.observers)
+	e.start()
+	if next == len(p.actions) {
+		return e.finish(p.record(JournalCommit, 0, nil))
+	}
+	return e.finish(p.execute(e, next, previous, params))
+}
+
+// RollbackFromJournal calls the Backward function of every action recorded
+// as completed (and not yet rolled back) in the journal of an interrupted
+// pipeline, in reverse order. When some of the roll backs fail, it returns a
+// *PipelineError without Cause, naming the interrupted step.
+func (p *Pipeline) RollbackFromJournal(params ...interface{}) error {
+	next, _, _, err := p.restore()
+	if err != nil {
+		return err
+	}
+	e := newExecution(context.Background(), p.id, len(p.actions), p.observers)
+	e.start()
+	if errs := p.rollback(e, next-1, params); len(errs) > 0 {
+		perr := &PipelineError{Step: next, Rollback: errs}
+		if next < len(p.actions) {
+			perr.Action = p.actions[next].Name
+		}
+		return e.finish(perr)
+	}
+	return e.finish(nil)
+}
+
+// restore reads the journal of the pipeline and restores the results of the
+// completed actions. It returns the index of the first action that did not
+// complete, the result of the action before it and whether a roll back was
+// in progress.
+func (p *Pipeline) restore() (int, Result, bool, error) {
+	if p.journal == nil {
+		return 0, nil, false, ErrNoJournal
+	}
+	entries, err := p.journal.Entries(p.id)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if len(entries) == 0 {
+		return 0, nil, false, ErrJournalNotFound
+	}
+	sort.Sort(journalEntries(entries))
+	var rollingBack bool
+	results := make(map[int]json.RawMessage)
+	for _, e := range entries {
+		switch e.Event {
+		case JournalStepDone:
+			results[e.Step] = e.Result
+		case JournalRollback:
+			rollingBack = true
+			delete(results, e.Step)
+		case JournalCommit, JournalAbort:
+			return 0, nil, false, ErrPipelineFinished
+		}
+	}
+	p.seq = entries[len(entries)-1].Seq + 1
+	var (
+		next     int
+		previous Result
+	)
+	for ; next < len(p.actions); next++ {
+		data, ok := results[next]
+		if !ok {
+			break
+		}
+		a := p.actions[next]
+		r, err := a.decodeResult(data)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		a.rMutex.Lock()
+		a.result = r
+		a.rMutex.Unlock()
+		previous = r
+	}
+	return next, previous, rollingBack, nil
+}
+
+func (a *Action) decodeResult(data json.RawMessage) (Result, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if a.DecodeResult != nil {
+		return a.DecodeResult(data)
+	}
+	var r interface{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type journalEntries []JournalEntry
+
+func (l journalEntries) Len() int           { return len(l) }
+func (l journalEntries) Less(i, j int) bool { return l[i].Seq < l[j].Seq }
+func (l journalEntries) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// FileJournal is a JournalStore that keeps the journal of each pipeline in a
+// file of JSON lines, inside a directory.
+type FileJournal struct {
+	// Directory of the journal files.
+	Dir string
+
+	fs fs.Fs
+	mu sync.Mutex
+}
+
+// NewFileJournal creates a FileJournal that keeps the journal files in the
+// given directory, creating it if needed.
+func NewFileJournal(dir string) (*FileJournal, error) {
+	j := &FileJournal{Dir: dir}
+	if err := j.filesystem().MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *FileJournal) filesystem() fs.Fs {
+	if j.fs == nil {
+		j.fs = fs.OsFs{}
+	}
+	return j.fs
+}
+
+// path returns the file of the journal of a pipeline, its ID being escaped
+// so distinct IDs never share a file, nor reach out of the directory.
+func (j *FileJournal) path(pipeline string) string {
+	return filepath.Join(j.Dir, url.QueryEscape(pipeline)+".journal")
+}
+
+func (j *FileJournal) Append(entry JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := j.filesystem().OpenFile(j.path(entry.Pipeline), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if s, ok := f.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (j *FileJournal) Entries(pipeline string) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := j.filesystem().Open(j.path(pipeline))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// skips torn writes left by a crash.
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,115 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/megamsys/libgo/db"
+)
+
+const (
+	JOURNALBUCKET = "pipeline_journal"
+
+	// Maximum number of entries read for a pipeline.
+	journalLimit = 10000
+)
+
+// ScyllaJournal is a JournalStore that keeps the journal in a Scylla table,
+// through the db package. The table is created by the migration
+// 0002_create_pipeline_journal of db/migrations.
+type ScyllaJournal struct {
+	Hosts    []string
+	Keyspace string
+	Username string
+	Password string
+}
+
+type journalRow struct {
+	Pipeline  string    `json:"pipeline" cql:"pipeline" cqlkey:"partition"`
+	Seq       int       `json:"seq" cql:"seq" cqlkey:"clustering"`
+	Event     string    `json:"event" cql:"event"`
+	Step      int       `json:"step" cql:"step"`
+	Action    string    `json:"action" cql:"action"`
+	Result    string    `json:"result" cql:"result"`
+	Error     string    `json:"error" cql:"error"`
+	CreatedAt time.Time `json:"created_at" cql:"created_at"`
+}
+
+func (journalRow) TableName() string {
+	return JOURNALBUCKET
+}
+
+func (j *ScyllaJournal) repository() (*db.Repository, error) {
+	return db.NewRepository(journalRow{}, db.Options{
+		Hosts:    j.Hosts,
+		Keyspace: j.Keyspace,
+		Username: j.Username,
+		Password: j.Password,
+		// An entry is written once at its (pipeline, seq) key.
+		Idempotent: true,
+	})
+}
+
+func (j *ScyllaJournal) Append(entry JournalEntry) error {
+	row := journalRow{
+		Pipeline:  entry.Pipeline,
+		Seq:       entry.Seq,
+		Event:     string(entry.Event),
+		Step:      entry.Step,
+		Action:    entry.Action,
+		Result:    string(entry.Result),
+		Error:     entry.Error,
+		CreatedAt: entry.Time,
+	}
+	r, err := j.repository()
+	if err != nil {
+		return err
+	}
+	return r.Put(context.Background(), row)
+}
+
+func (j *ScyllaJournal) Entries(pipeline string) ([]JournalEntry, error) {
+	r, err := j.repository()
+	if err != nil {
+		return nil, err
+	}
+	rows := &[]journalRow{}
+	where := map[string]interface{}{"pipeline": pipeline}
+	if err := r.List(context.Background(), where, db.ListOptions{Limit: journalLimit}, rows); err != nil {
+		return nil, err
+	}
+	entries := make([]JournalEntry, 0, len(*rows))
+	for _, row := range *rows {
+		e := JournalEntry{
+			Pipeline: row.Pipeline,
+			Seq:      row.Seq,
+			Event:    JournalEvent(row.Event),
+			Step:     row.Step,
+			Action:   row.Action,
+			Error:    row.Error,
+			Time:     row.CreatedAt,
+		}
+		if row.Result != "" {
+			e.Result = json.RawMessage(row.Result)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
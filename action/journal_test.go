@@ -0,0 +1,247 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type disk struct {
+	Id string `json:"id"`
+}
+
+func journalEvents(c *check.C, j JournalStore, id string) []JournalEvent {
+	entries, err := j.Entries(id)
+	c.Assert(err, check.IsNil)
+	events := make([]JournalEvent, len(entries))
+	for i, e := range entries {
+		c.Assert(e.Seq, check.Equals, i)
+		events[i] = e.Event
+	}
+	return events
+}
+
+func (s *S) TestJournalRecordsExecution(c *check.C) {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	pipeline := NewPipeline(&helloAction, &unrollbackableAction).WithJournal("p1", j)
+	err = pipeline.Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(journalEvents(c, j, "p1"), check.DeepEquals, []JournalEvent{
+		JournalStart, JournalStepStart, JournalStepDone, JournalStepStart, JournalStepDone, JournalCommit,
+	})
+	entries, err := j.Entries("p1")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries[2].Action, check.Equals, "hello")
+	c.Assert(string(entries[2].Result), check.Equals, `"success"`)
+	c.Assert(entries[4].Result, check.IsNil)
+}
+
+func (s *S) TestJournalRecordsRollback(c *check.C) {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	pipeline := NewPipeline(&helloAction, &errorAction).WithJournal("p1", j)
+	err = pipeline.Execute()
+	c.Assert(err, check.NotNil)
+	c.Assert(journalEvents(c, j, "p1"), check.DeepEquals, []JournalEvent{
+		JournalStart, JournalStepStart, JournalStepDone, JournalStepStart, JournalRollback, JournalAbort,
+	})
+}
+
+func (s *S) TestJournalContinuesExistingEntries(c *check.C) {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	err = NewPipeline(&helloAction, &errorAction).WithJournal("p1", j).Execute()
+	c.Assert(err, check.NotNil)
+	err = NewPipeline(&helloAction).WithJournal("p1", j).Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(journalEvents(c, j, "p1"), check.DeepEquals, []JournalEvent{
+		JournalStart, JournalStepStart, JournalStepDone, JournalStepStart, JournalRollback, JournalAbort,
+		JournalStart, JournalStepStart, JournalStepDone, JournalCommit,
+	})
+}
+
+// interruptedJournal simulates a process that died while running the second
+// step of a pipeline.
+func interruptedJournal(c *check.C) *FileJournal {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	entries := []JournalEntry{
+		{Event: JournalStart},
+		{Event: JournalStepStart, Step: 0, Action: "create-disk"},
+		{Event: JournalStepDone, Step: 0, Action: "create-disk", Result: json.RawMessage(`{"id":"disk-1"}`)},
+		{Event: JournalStepStart, Step: 1, Action: "boot"},
+	}
+	for i, e := range entries {
+		e.Pipeline = "p1"
+		e.Seq = i
+		c.Assert(j.Append(e), check.IsNil)
+	}
+	return j
+}
+
+func resumableActions(created, booted, removed *interface{}) []*Action {
+	return []*Action{
+		{
+			Name: "create-disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				*created = true
+				return disk{Id: "disk-2"}, nil
+			},
+			Backward: func(ctx BWContext) {
+				*removed = ctx.FWResult
+			},
+			DecodeResult: func(data []byte) (Result, error) {
+				var d disk
+				err := json.Unmarshal(data, &d)
+				return d, err
+			},
+		},
+		{
+			Name: "boot",
+			Forward: func(ctx FWContext) (Result, error) {
+				*booted = ctx.Previous
+				return nil, nil
+			},
+		},
+	}
+}
+
+func (s *S) TestResume(c *check.C) {
+	var created, booted, removed interface{}
+	j := interruptedJournal(c)
+	pipeline := NewPipeline(resumableActions(&created, &booted, &removed)...).WithJournal("p1", j)
+	err := pipeline.Resume()
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.IsNil)
+	c.Assert(booted, check.Equals, disk{Id: "disk-1"})
+	c.Assert(journalEvents(c, j, "p1"), check.DeepEquals, []JournalEvent{
+		JournalStart, JournalStepStart, JournalStepDone, JournalStepStart, JournalStepStart, JournalStepDone, JournalCommit,
+	})
+	err = pipeline.Resume()
+	c.Assert(err, check.Equals, ErrPipelineFinished)
+}
+
+func (s *S) TestRollbackFromJournal(c *check.C) {
+	var created, booted, removed interface{}
+	j := interruptedJournal(c)
+	pipeline := NewPipeline(resumableActions(&created, &booted, &removed)...).WithJournal("p1", j)
+	err := pipeline.RollbackFromJournal()
+	c.Assert(err, check.IsNil)
+	c.Assert(removed, check.Equals, disk{Id: "disk-1"})
+	c.Assert(booted, check.IsNil)
+	c.Assert(journalEvents(c, j, "p1"), check.DeepEquals, []JournalEvent{
+		JournalStart, JournalStepStart, JournalStepDone, JournalStepStart, JournalRollback, JournalAbort,
+	})
+	err = pipeline.RollbackFromJournal()
+	c.Assert(err, check.Equals, ErrPipelineFinished)
+}
+
+func (s *S) TestResumeWhileRollingBack(c *check.C) {
+	j := interruptedJournal(c)
+	err := j.Append(JournalEntry{Pipeline: "p1", Seq: 4, Event: JournalRollback, Step: 0})
+	c.Assert(err, check.IsNil)
+	pipeline := NewPipeline(&helloAction, &helloAction).WithJournal("p1", j)
+	c.Assert(pipeline.Resume(), check.Equals, ErrRollingBack)
+}
+
+func (s *S) TestResumeWithoutJournal(c *check.C) {
+	pipeline := NewPipeline(&helloAction)
+	c.Assert(pipeline.Resume(), check.Equals, ErrNoJournal)
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	pipeline.WithJournal("unknown", j)
+	c.Assert(pipeline.Resume(), check.Equals, ErrJournalNotFound)
+}
+
+func (s *S) TestFileJournalSkipsTornWrites(c *check.C) {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	c.Assert(j.Append(JournalEntry{Pipeline: "p1", Event: JournalStart}), check.IsNil)
+	f, err := os.OpenFile(filepath.Join(j.Dir, "p1.journal"), os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, check.IsNil)
+	f.WriteString("{\"pipeline\":\"p1\",\"se\n")
+	f.Close()
+	c.Assert(j.Append(JournalEntry{Pipeline: "p1", Seq: 1, Event: JournalStepStart}), check.IsNil)
+	entries, err := j.Entries("p1")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 2)
+}
+
+func (s *S) TestFileJournalPaths(c *check.C) {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	for _, id := range []string{"a/x", "b/x", "../x"} {
+		c.Assert(j.Append(JournalEntry{Pipeline: id, Event: JournalStart}), check.IsNil)
+	}
+	for _, id := range []string{"a/x", "b/x", "../x"} {
+		entries, err := j.Entries(id)
+		c.Assert(err, check.IsNil)
+		c.Assert(entries, check.HasLen, 1)
+		c.Assert(entries[0].Pipeline, check.Equals, id)
+	}
+	c.Assert(j.path("a/x"), check.Equals, filepath.Join(j.Dir, "a%2Fx.journal"))
+}
+
+func (s *S) TestJournalRecordsRollbackErrors(c *check.C) {
+	j, err := NewFileJournal(c.MkDir())
+	c.Assert(err, check.IsNil)
+	myAction := Action{
+		Name: "disk",
+		Forward: func(ctx FWContext) (Result, error) {
+			return "disk-1", nil
+		},
+		FallibleBackward: func(ctx BWContext) error {
+			return errors.New("disk is busy")
+		},
+	}
+	pipeline := NewPipeline(&myAction, &errorAction).WithJournal("p1", j)
+	err = pipeline.Execute()
+	c.Assert(err, check.FitsTypeOf, &PipelineError{})
+	entries, err := j.Entries("p1")
+	c.Assert(err, check.IsNil)
+	c.Assert(entries[4].Event, check.Equals, JournalRollback)
+	c.Assert(entries[4].Error, check.Equals, "disk is busy")
+}
+
+func (s *S) TestRollbackFromJournalErrors(c *check.C) {
+	j := interruptedJournal(c)
+	actions := []*Action{
+		{
+			Name: "create-disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				return nil, nil
+			},
+			FallibleBackward: func(ctx BWContext) error {
+				return errors.New("disk is busy")
+			},
+		},
+		{Name: "boot", Forward: helloAction.Forward},
+	}
+	pipeline := NewPipeline(actions...).WithJournal("p1", j)
+	err := pipeline.RollbackFromJournal()
+	c.Assert(err, check.ErrorMatches, `rollback failed \(step 0: create-disk action: disk is busy\)`)
+	perr := err.(*PipelineError)
+	c.Assert(perr.Step, check.Equals, 1)
+	c.Assert(perr.Action, check.Equals, "boot")
+	c.Assert(perr.Cause, check.IsNil)
+}
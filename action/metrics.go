@@ -0,0 +1,194 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the latency histograms of Metrics
+// created without buckets.
+var DefaultBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// ActionStats are the metrics recorded for an action.
+type ActionStats struct {
+	Success         uint64
+	Failure         uint64
+	Rollback        uint64
+	RollbackFailure uint64
+
+	// Latency histogram. Counts[i] is the number of executions that took
+	// at most Buckets[i] and more than Buckets[i-1]. The last count is for
+	// executions slower than every bucket.
+	Buckets []time.Duration
+	Counts  []uint64
+
+	// Total time spent in the action.
+	Sum time.Duration
+}
+
+// Metrics is a PipelineObserver that records, for each action name, the
+// number of successful and failed executions and roll backs, and a histogram
+// of the execution latencies. It also implements http.Handler, serving the
+// metrics in the Prometheus text format.
+type Metrics struct {
+	buckets []time.Duration
+
+	mu        sync.Mutex
+	actions   map[string]*ActionStats
+	succeeded uint64
+	failed    uint64
+}
+
+// NewMetrics creates a Metrics with the given histogram buckets, which must
+// be sorted. When no bucket is given, DefaultBuckets are used.
+func NewMetrics(buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &Metrics{buckets: buckets, actions: make(map[string]*ActionStats)}
+}
+
+func (m *Metrics) stats(action string) *ActionStats {
+	st, ok := m.actions[action]
+	if !ok {
+		st = &ActionStats{Buckets: m.buckets, Counts: make([]uint64, len(m.buckets)+1)}
+		m.actions[action] = st
+	}
+	return st
+}
+
+func (m *Metrics) OnStart(e *Execution) {}
+
+func (m *Metrics) OnStepStart(e *Execution, step int, action string) {}
+
+func (m *Metrics) OnStepEnd(e *Execution, step int, action string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.stats(action)
+	if err != nil {
+		st.Failure++
+	} else {
+		st.Success++
+	}
+	st.Sum += d
+	i := sort.Search(len(m.buckets), func(i int) bool { return d <= m.buckets[i] })
+	st.Counts[i]++
+}
+
+func (m *Metrics) OnRollbackStep(e *Execution, step int, action string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.stats(action)
+	st.Rollback++
+	if err != nil {
+		st.RollbackFailure++
+	}
+}
+
+func (m *Metrics) OnFinish(e *Execution, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.failed++
+	} else {
+		m.succeeded++
+	}
+}
+
+// Actions returns a copy of the metrics of every action.
+func (m *Metrics) Actions() map[string]ActionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actions := make(map[string]ActionStats, len(m.actions))
+	for name, st := range m.actions {
+		c := *st
+		c.Counts = append([]uint64(nil), st.Counts...)
+		actions[name] = c
+	}
+	return actions
+}
+
+// Pipelines returns the number of executions that succeeded and failed.
+func (m *Metrics) Pipelines() (succeeded, failed uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.succeeded, m.failed
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	actions := m.Actions()
+	succeeded, failed := m.Pipelines()
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(&buf, "# TYPE pipeline_executions_total counter")
+	fmt.Fprintf(&buf, "pipeline_executions_total{result=\"success\"} %d\n", succeeded)
+	fmt.Fprintf(&buf, "pipeline_executions_total{result=\"failure\"} %d\n", failed)
+	fmt.Fprintln(&buf, "# TYPE action_executions_total counter")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "action_executions_total{action=%q,result=\"success\"} %d\n", name, actions[name].Success)
+		fmt.Fprintf(&buf, "action_executions_total{action=%q,result=\"failure\"} %d\n", name, actions[name].Failure)
+	}
+	fmt.Fprintln(&buf, "# TYPE action_rollbacks_total counter")
+	for _, name := range names {
+		st := actions[name]
+		fmt.Fprintf(&buf, "action_rollbacks_total{action=%q,result=\"success\"} %d\n", name, st.Rollback-st.RollbackFailure)
+		fmt.Fprintf(&buf, "action_rollbacks_total{action=%q,result=\"failure\"} %d\n", name, st.RollbackFailure)
+	}
+	fmt.Fprintln(&buf, "# TYPE action_duration_seconds histogram")
+	for _, name := range names {
+		st := actions[name]
+		var count uint64
+		for i, b := range st.Buckets {
+			count += st.Counts[i]
+			fmt.Fprintf(&buf, "action_duration_seconds_bucket{action=%q,le=\"%g\"} %d\n", name, b.Seconds(), count)
+		}
+		count += st.Counts[len(st.Buckets)]
+		fmt.Fprintf(&buf, "action_duration_seconds_bucket{action=%q,le=\"+Inf\"} %d\n", name, count)
+		fmt.Fprintf(&buf, "action_duration_seconds_sum{action=%q} %g\n", name, st.Sum.Seconds())
+		fmt.Fprintf(&buf, "action_duration_seconds_count{action=%q} %d\n", name, count)
+	}
+	return buf.WriteTo(w)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
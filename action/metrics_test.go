@@ -0,0 +1,88 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMetricsCounters(c *check.C) {
+	m := NewMetrics()
+	myAction := Action{
+		Name: "disk",
+		Forward: func(ctx FWContext) (Result, error) {
+			return "disk-1", nil
+		},
+		FallibleBackward: func(ctx BWContext) error {
+			return errors.New("busy")
+		},
+	}
+	c.Assert(NewPipeline(&myAction).WithObserver(m).Execute(), check.IsNil)
+	c.Assert(NewPipeline(&myAction, &errorAction).WithObserver(m).Execute(), check.NotNil)
+	actions := m.Actions()
+	c.Assert(actions, check.HasLen, 2)
+	disk := actions["disk"]
+	c.Assert(disk.Success, check.Equals, uint64(2))
+	c.Assert(disk.Failure, check.Equals, uint64(0))
+	c.Assert(disk.Rollback, check.Equals, uint64(1))
+	c.Assert(disk.RollbackFailure, check.Equals, uint64(1))
+	c.Assert(actions["error"].Failure, check.Equals, uint64(1))
+	succeeded, failed := m.Pipelines()
+	c.Assert(succeeded, check.Equals, uint64(1))
+	c.Assert(failed, check.Equals, uint64(1))
+}
+
+func (s *S) TestMetricsHistogram(c *check.C) {
+	m := NewMetrics(10*time.Millisecond, time.Second)
+	m.OnStepEnd(nil, 0, "boot", 5*time.Millisecond, nil)
+	m.OnStepEnd(nil, 0, "boot", 10*time.Millisecond, nil)
+	m.OnStepEnd(nil, 0, "boot", 500*time.Millisecond, nil)
+	m.OnStepEnd(nil, 0, "boot", time.Minute, errors.New("timeout"))
+	boot := m.Actions()["boot"]
+	c.Assert(boot.Counts, check.DeepEquals, []uint64{2, 1, 1})
+	c.Assert(boot.Sum, check.Equals, time.Minute+515*time.Millisecond)
+}
+
+func (s *S) TestMetricsServeHTTP(c *check.C) {
+	m := NewMetrics(time.Second)
+	m.OnStepEnd(nil, 0, "boot", 500*time.Millisecond, nil)
+	m.OnStepEnd(nil, 0, "boot", 2*time.Second, nil)
+	m.OnFinish(nil, time.Second, nil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	c.Assert(err, check.IsNil)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/plain; version=0.0.4")
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`pipeline_executions_total{result="success"} 1`,
+		`action_executions_total{action="boot",result="success"} 2`,
+		`action_duration_seconds_bucket{action="boot",le="1"} 1`,
+		`action_duration_seconds_bucket{action="boot",le="+Inf"} 2`,
+		`action_duration_seconds_sum{action="boot"} 2.5`,
+		`action_duration_seconds_count{action="boot"} 2`,
+	} {
+		c.Assert(strings.Contains(body, line+"\n"), check.Equals, true, check.Commentf("missing %q in\n%s", line, body))
+	}
+}
@@ -0,0 +1,138 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PipelineObserver is notified of the progress of pipelines and graphs, for
+// metrics and tracing. Observers attached globally, and the ones of graphs,
+// may be called from several goroutines at once.
+type PipelineObserver interface {
+	// OnStart is called when an execution starts.
+	OnStart(e *Execution)
+
+	// OnStepStart is called before an action runs.
+	OnStepStart(e *Execution, step int, action string)
+
+	// OnStepEnd is called after an action ran, with the time it took
+	// (retries included) and its error.
+	OnStepEnd(e *Execution, step int, action string, d time.Duration, err error)
+
+	// OnRollbackStep is called after an action was rolled back, with the
+	// error of its FallibleBackward function.
+	OnRollbackStep(e *Execution, step int, action string, err error)
+
+	// OnFinish is called when an execution ends, with its duration and the
+	// error returned to the caller.
+	OnFinish(e *Execution, d time.Duration, err error)
+}
+
+var (
+	observers []PipelineObserver
+	obsMutex  sync.RWMutex
+)
+
+// AddObserver attaches an observer to every pipeline and graph.
+func AddObserver(o PipelineObserver) {
+	obsMutex.Lock()
+	defer obsMutex.Unlock()
+	observers = append(observers, o)
+}
+
+// WithObserver attaches an observer to the pipeline. It returns the pipeline
+// itself.
+func (p *Pipeline) WithObserver(o PipelineObserver) *Pipeline {
+	p.observers = append(p.observers, o)
+	return p
+}
+
+// WithObserver attaches an observer to the graph. It returns the graph
+// itself.
+func (g *Graph) WithObserver(o PipelineObserver) *Graph {
+	g.observers = append(g.observers, o)
+	return g
+}
+
+// Execution is a run of a pipeline or graph, as reported to observers. The
+// same pointer is given to every call of a run, so observers can use it to
+// correlate them.
+type Execution struct {
+	// Identifier of the pipeline in the journal, if any.
+	ID string
+
+	// Number of actions.
+	Steps int
+
+	// Context given to the executor.
+	Context context.Context
+
+	// Time the execution started.
+	Started time.Time
+
+	observers []PipelineObserver
+}
+
+func newExecution(ctx context.Context, id string, steps int, local []PipelineObserver) *Execution {
+	obsMutex.RLock()
+	all := make([]PipelineObserver, 0, len(observers)+len(local))
+	all = append(all, observers...)
+	obsMutex.RUnlock()
+	return &Execution{
+		ID:        id,
+		Steps:     steps,
+		Context:   ctx,
+		Started:   time.Now(),
+		observers: append(all, local...),
+	}
+}
+
+func (e *Execution) start() {
+	for _, o := range e.observers {
+		o.OnStart(e)
+	}
+}
+
+func (e *Execution) stepStart(step int, a *Action) {
+	for _, o := range e.observers {
+		o.OnStepStart(e, step, a.Name)
+	}
+}
+
+func (e *Execution) stepEnd(step int, a *Action, d time.Duration, err error) {
+	for _, o := range e.observers {
+		o.OnStepEnd(e, step, a.Name, d, err)
+	}
+}
+
+func (e *Execution) rollbackStep(step int, a *Action, err error) {
+	for _, o := range e.observers {
+		o.OnRollbackStep(e, step, a.Name, err)
+	}
+}
+
+// finish notifies the end of the execution and returns its error.
+func (e *Execution) finish(err error) error {
+	d := time.Since(e.Started)
+	for _, o := range e.observers {
+		o.OnFinish(e, d, err)
+	}
+	return err
+}
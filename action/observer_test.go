@@ -0,0 +1,128 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+type recordingObserver struct {
+	mu    sync.Mutex
+	calls []string
+	execs map[*Execution]bool
+}
+
+func (o *recordingObserver) add(e *Execution, format string, args ...interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.execs == nil {
+		o.execs = make(map[*Execution]bool)
+	}
+	o.execs[e] = true
+	o.calls = append(o.calls, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) OnStart(e *Execution) {
+	o.add(e, "start %d", e.Steps)
+}
+
+func (o *recordingObserver) OnStepStart(e *Execution, step int, action string) {
+	o.add(e, "step-start %d %s", step, action)
+}
+
+func (o *recordingObserver) OnStepEnd(e *Execution, step int, action string, d time.Duration, err error) {
+	o.add(e, "step-end %d %s %v", step, action, err)
+}
+
+func (o *recordingObserver) OnRollbackStep(e *Execution, step int, action string, err error) {
+	o.add(e, "rollback %d %s %v", step, action, err)
+}
+
+func (o *recordingObserver) OnFinish(e *Execution, d time.Duration, err error) {
+	o.add(e, "finish %v", err)
+}
+
+func (s *S) TestPipelineObserver(c *check.C) {
+	var o recordingObserver
+	myAction := Action{
+		Name: "disk",
+		Forward: func(ctx FWContext) (Result, error) {
+			return "disk-1", nil
+		},
+		FallibleBackward: func(ctx BWContext) error {
+			return errors.New("busy")
+		},
+	}
+	pipeline := NewPipeline(&myAction, &errorAction).WithObserver(&o)
+	err := pipeline.Execute()
+	c.Assert(err, check.NotNil)
+	c.Assert(o.calls, check.DeepEquals, []string{
+		"start 2",
+		"step-start 0 disk",
+		"step-end 0 disk <nil>",
+		"step-start 1 error",
+		"step-end 1 error Failed to execute.",
+		"rollback 0 disk busy",
+		"finish Failed to execute.; rollback failed (step 0: disk action: busy)",
+	})
+	c.Assert(o.execs, check.HasLen, 1)
+}
+
+func (s *S) TestGlobalObserver(c *check.C) {
+	defer func() {
+		observers = nil
+	}()
+	var global, local recordingObserver
+	AddObserver(&global)
+	err := NewPipeline(&helloAction).WithObserver(&local).Execute()
+	c.Assert(err, check.IsNil)
+	err = NewPipeline(&helloAction).Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(global.calls, check.HasLen, 8)
+	c.Assert(global.execs, check.HasLen, 2)
+	c.Assert(local.calls, check.DeepEquals, []string{
+		"start 1",
+		"step-start 0 hello",
+		"step-end 0 hello <nil>",
+		"finish <nil>",
+	})
+}
+
+func (s *S) TestGraphObserver(c *check.C) {
+	var o recordingObserver
+	g, err := NewGraph(
+		&Action{Name: "disk", Forward: helloAction.Forward},
+		&Action{Name: "boot", DependsOn: []string{"disk"}, Forward: errorAction.Forward},
+	)
+	c.Assert(err, check.IsNil)
+	err = g.WithObserver(&o).Execute()
+	c.Assert(err, check.NotNil)
+	c.Assert(o.calls, check.DeepEquals, []string{
+		"start 2",
+		"step-start 0 disk",
+		"step-end 0 disk <nil>",
+		"step-start 1 boot",
+		"step-end 1 boot Failed to execute.",
+		"rollback 0 disk <nil>",
+		"finish Failed to execute.",
+	})
+}
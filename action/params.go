@@ -0,0 +1,177 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	liberrors "github.com/megamsys/libgo/errors"
+)
+
+// Key is a named and typed parameter of a pipeline. Keys registered in a
+// pipeline are validated against the given Values before the first action
+// runs, so Forward functions can read them without checking.
+type Key struct {
+	Name string
+
+	// Type of the values. Values must be assignable to it.
+	Type reflect.Type
+
+	// Whether the pipeline refuses to run without a value for the key.
+	Required bool
+}
+
+// NewKey declares an optional parameter whose values have the type of the
+// given sample value.
+func NewKey(name string, sample interface{}) *Key {
+	return &Key{Name: name, Type: reflect.TypeOf(sample)}
+}
+
+// RequiredKey declares a required parameter whose values have the type of
+// the given sample value.
+func RequiredKey(name string, sample interface{}) *Key {
+	return &Key{Name: name, Type: reflect.TypeOf(sample), Required: true}
+}
+
+// Values are the named parameters given to a pipeline, by key name.
+type Values map[string]interface{}
+
+// Scan stores the value of the key in the variable pointed by dst.
+func (v Values) Scan(k *Key, dst interface{}) error {
+	return assign(fmt.Sprintf("Parameter %q", k.Name), v[k.Name], dst)
+}
+
+// String returns the value of a string key, or "" when it has no value.
+func (v Values) String(k *Key) string {
+	s, _ := v[k.Name].(string)
+	return s
+}
+
+// Int returns the value of an int key, or 0 when it has no value.
+func (v Values) Int(k *Key) int {
+	i, _ := v[k.Name].(int)
+	return i
+}
+
+// Bool returns the value of a bool key, or false when it has no value.
+func (v Values) Bool(k *Key) bool {
+	b, _ := v[k.Name].(bool)
+	return b
+}
+
+// PreviousAs stores the result of the previous action in the variable
+// pointed by dst, failing when its type does not match.
+func (ctx FWContext) PreviousAs(dst interface{}) error {
+	return assign("Previous result", ctx.Previous, dst)
+}
+
+// DependencyAs stores the result of the named dependency in the variable
+// pointed by dst, failing when its type does not match.
+func (ctx FWContext) DependencyAs(name string, dst interface{}) error {
+	r, ok := ctx.Dependencies[name]
+	if !ok {
+		return fmt.Errorf("Action does not depend on %q.", name)
+	}
+	return assign(fmt.Sprintf("Result of %q", name), r, dst)
+}
+
+// ResultAs stores the result of the forward phase in the variable pointed by
+// dst, failing when its type does not match.
+func (ctx BWContext) ResultAs(dst interface{}) error {
+	return assign("Forward result", ctx.FWResult, dst)
+}
+
+func assign(what string, v interface{}, dst interface{}) error {
+	ptr := reflect.ValueOf(dst)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("Cannot store %s in %T, it is not a pointer.", what, dst)
+	}
+	target := ptr.Elem()
+	if v == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+	val := reflect.ValueOf(v)
+	if !val.Type().AssignableTo(target.Type()) {
+		return fmt.Errorf("%s is %T, not %s.", what, v, target.Type())
+	}
+	target.Set(val)
+	return nil
+}
+
+// WithKeys registers the parameters of the pipeline. It returns the pipeline
+// itself.
+func (p *Pipeline) WithKeys(keys ...*Key) *Pipeline {
+	p.keys = append(p.keys, keys...)
+	return p
+}
+
+// WithValues sets the named parameters given to the actions, in
+// FWContext.Values and BWContext.Values. It returns the pipeline itself.
+func (p *Pipeline) WithValues(values Values) *Pipeline {
+	p.values = values
+	return p
+}
+
+// WithKeys registers the parameters of the graph. It returns the graph
+// itself.
+func (g *Graph) WithKeys(keys ...*Key) *Graph {
+	g.keys = append(g.keys, keys...)
+	return g
+}
+
+// WithValues sets the named parameters given to the actions of the graph.
+// It returns the graph itself.
+func (g *Graph) WithValues(values Values) *Graph {
+	g.values = values
+	return g
+}
+
+// validateValues checks the values against the registered keys. Without
+// registered keys, any value is accepted.
+func validateValues(keys []*Key, values Values) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		known[k.Name] = true
+		v, ok := values[k.Name]
+		if !ok || v == nil {
+			if k.Required {
+				return &liberrors.ValidationError{Message: fmt.Sprintf("Missing required parameter %q.", k.Name)}
+			}
+			continue
+		}
+		if k.Type != nil && !reflect.TypeOf(v).AssignableTo(k.Type) {
+			return &liberrors.ValidationError{Message: fmt.Sprintf("Parameter %q must be %s, not %T.", k.Name, k.Type, v)}
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !known[name] {
+			return &liberrors.ValidationError{Message: fmt.Sprintf("Unknown parameter %q.", name)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"time"
+
+	liberrors "github.com/megamsys/libgo/errors"
+	"gopkg.in/check.v1"
+)
+
+var (
+	imageKey   = RequiredKey("image", "")
+	cpusKey    = RequiredKey("cpus", 0)
+	timeoutKey = NewKey("timeout", time.Duration(0))
+)
+
+func (s *S) TestValuesGivenToActions(c *check.C) {
+	var image string
+	var cpus int
+	var timeout time.Duration
+	myAction := Action{
+		Forward: func(ctx FWContext) (Result, error) {
+			image = ctx.Values.String(imageKey)
+			cpus = ctx.Values.Int(cpusKey)
+			err := ctx.Values.Scan(timeoutKey, &timeout)
+			c.Assert(err, check.IsNil)
+			c.Assert(ctx.Params, check.DeepEquals, []interface{}{"legacy"})
+			return "ok", nil
+		},
+	}
+	pipeline := NewPipeline(&myAction).
+		WithKeys(imageKey, cpusKey, timeoutKey).
+		WithValues(Values{"image": "ubuntu", "cpus": 2, "timeout": time.Minute})
+	err := pipeline.Execute("legacy")
+	c.Assert(err, check.IsNil)
+	c.Assert(image, check.Equals, "ubuntu")
+	c.Assert(cpus, check.Equals, 2)
+	c.Assert(timeout, check.Equals, time.Minute)
+}
+
+func (s *S) TestValuesValidatedBeforeFirstStep(c *check.C) {
+	var called bool
+	myAction := Action{
+		Forward: func(ctx FWContext) (Result, error) {
+			called = true
+			return nil, nil
+		},
+	}
+	tests := []struct {
+		values Values
+		msg    string
+	}{
+		{Values{"cpus": 2}, `Missing required parameter "image".`},
+		{Values{"image": "ubuntu", "cpus": "2"}, `Parameter "cpus" must be int, not string.`},
+		{Values{"image": "ubuntu", "cpus": 2, "memory": 1024}, `Unknown parameter "memory".`},
+	}
+	for _, t := range tests {
+		pipeline := NewPipeline(&myAction).WithKeys(imageKey, cpusKey, timeoutKey).WithValues(t.values)
+		err := pipeline.Execute()
+		c.Assert(err, check.FitsTypeOf, &liberrors.ValidationError{})
+		c.Assert(err.Error(), check.Equals, t.msg)
+	}
+	c.Assert(called, check.Equals, false)
+}
+
+func (s *S) TestValuesWithoutKeys(c *check.C) {
+	pipeline := NewPipeline(&helloAction).WithValues(Values{"anything": 1})
+	c.Assert(pipeline.Execute(), check.IsNil)
+}
+
+func (s *S) TestPreviousAs(c *check.C) {
+	var got disk
+	actions := []*Action{
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				return disk{Id: "disk-1"}, nil
+			},
+		},
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				var wrong string
+				c.Assert(ctx.PreviousAs(&wrong), check.ErrorMatches, `Previous result is action.disk, not string.`)
+				c.Assert(ctx.PreviousAs(got), check.ErrorMatches, `Cannot store Previous result in action.disk, it is not a pointer.`)
+				return nil, ctx.PreviousAs(&got)
+			},
+		},
+	}
+	err := NewPipeline(actions...).Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.Equals, disk{Id: "disk-1"})
+}
+
+func (s *S) TestDependencyAs(c *check.C) {
+	var got string
+	g, err := NewGraph(
+		&Action{Name: "hello", Forward: helloAction.Forward},
+		&Action{
+			Name:      "boot",
+			DependsOn: []string{"hello"},
+			Forward: func(ctx FWContext) (Result, error) {
+				c.Assert(ctx.DependencyAs("ip", &got), check.ErrorMatches, `Action does not depend on "ip".`)
+				return nil, ctx.DependencyAs("hello", &got)
+			},
+		},
+	)
+	c.Assert(err, check.IsNil)
+	c.Assert(g.Execute(), check.IsNil)
+	c.Assert(got, check.Equals, "success")
+}
+
+func (s *S) TestResultAs(c *check.C) {
+	var got string
+	myAction := Action{
+		Forward: helloAction.Forward,
+		Backward: func(ctx BWContext) {
+			c.Assert(ctx.ResultAs(&got), check.IsNil)
+		},
+	}
+	err := NewPipeline(&myAction, &errorAction).Execute()
+	c.Assert(err, check.NotNil)
+	c.Assert(got, check.Equals, "success")
+}
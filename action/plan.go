@@ -0,0 +1,106 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/megamsys/libgo/cmd"
+)
+
+// Planner is the function called when planning a pipeline (dry run). It
+// describes what the Forward function would do with the given context,
+// without side effects. As no action runs, FWContext.Previous is always nil.
+type Planner func(context FWContext) (string, error)
+
+// PlanStep describes an action of a Plan.
+type PlanStep struct {
+	// Index of the action in the pipeline.
+	Step int
+
+	// Name of the action.
+	Action string
+
+	// What the action would do, as described by its Plan function.
+	Description string
+
+	// Whether the action can be rolled back.
+	Undoable bool
+
+	// Problem found while planning the action, if any.
+	Err error
+}
+
+// Plan is the list of steps a pipeline would run, returned by
+// Pipeline.Plan.
+type Plan struct {
+	Steps []PlanStep
+}
+
+// Table renders the plan as a step list.
+func (pl *Plan) Table() *cmd.Table {
+	t := cmd.NewTable()
+	t.Headers = cmd.Row{"#", "Action", "Undoable", "Description", "Problem"}
+	for _, s := range pl.Steps {
+		undoable, problem := "no", ""
+		if s.Undoable {
+			undoable = "yes"
+		}
+		if s.Err != nil {
+			problem = s.Err.Error()
+		}
+		t.AddRow(cmd.Row{strconv.Itoa(s.Step), s.Action, undoable, s.Description, problem})
+	}
+	return t
+}
+
+// Plan walks the actions of the pipeline without running them, validating
+// the parameters and asking each action with a Plan function to describe
+// what it would do. It returns the plan along with the first problem found,
+// if any; every problem is also recorded in its step.
+func (p *Pipeline) Plan(params ...interface{}) (*Plan, error) {
+	if len(p.actions) == 0 {
+		return nil, errors.New("No actions to execute.")
+	}
+	if err := validateValues(p.keys, p.values); err != nil {
+		return nil, err
+	}
+	var first error
+	plan := &Plan{Steps: make([]PlanStep, len(p.actions))}
+	fwCtx := FWContext{Context: context.Background(), Params: params, Values: p.values}
+	for i, a := range p.actions {
+		s := PlanStep{
+			Step:     i,
+			Action:   a.Name,
+			Undoable: a.Backward != nil || a.FallibleBackward != nil,
+		}
+		if a.Forward == nil {
+			s.Err = errors.New("All actions must define the forward function.")
+		} else if len(params) < a.MinParams {
+			s.Err = errors.New("Not enough parameters to call Action.Forward.")
+		} else if a.Plan != nil {
+			s.Description, s.Err = a.Plan(fwCtx)
+		}
+		if s.Err != nil && first == nil {
+			first = s.Err
+		}
+		plan.Steps[i] = s
+	}
+	return plan, first
+}
@@ -0,0 +1,80 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"fmt"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestPlan(c *check.C) {
+	var executed bool
+	actions := []*Action{
+		{
+			Name: "create-disk",
+			Forward: func(ctx FWContext) (Result, error) {
+				executed = true
+				return nil, nil
+			},
+			Backward: func(ctx BWContext) {},
+			Plan: func(ctx FWContext) (string, error) {
+				return fmt.Sprintf("create a %s disk", ctx.Params[0]), nil
+			},
+			MinParams: 1,
+		},
+		{
+			Name: "boot",
+			Forward: func(ctx FWContext) (Result, error) {
+				executed = true
+				return nil, nil
+			},
+		},
+	}
+	plan, err := NewPipeline(actions...).Plan("10G")
+	c.Assert(err, check.IsNil)
+	c.Assert(executed, check.Equals, false)
+	c.Assert(plan.Steps, check.DeepEquals, []PlanStep{
+		{Step: 0, Action: "create-disk", Description: "create a 10G disk", Undoable: true},
+		{Step: 1, Action: "boot"},
+	})
+	expected := `+---+-------------+----------+-------------------+---------+
+| # | Action      | Undoable | Description       | Problem |
++---+-------------+----------+-------------------+---------+
+| 0 | create-disk | yes      | create a 10G disk |         |
+| 1 | boot        | no       |                   |         |
++---+-------------+----------+-------------------+---------+
+`
+	c.Assert(plan.Table().String(), check.Equals, expected)
+}
+
+func (s *S) TestPlanProblems(c *check.C) {
+	actions := []*Action{
+		{Name: "needs-params", Forward: helloAction.Forward, MinParams: 2},
+		{Name: "no-forward"},
+	}
+	plan, err := NewPipeline(actions...).Plan("one")
+	c.Assert(err, check.ErrorMatches, "Not enough parameters to call Action.Forward.")
+	c.Assert(plan.Steps, check.HasLen, 2)
+	c.Assert(plan.Steps[0].Err, check.ErrorMatches, "Not enough parameters to call Action.Forward.")
+	c.Assert(plan.Steps[1].Err, check.ErrorMatches, "All actions must define the forward function.")
+}
+
+func (s *S) TestPlanNoActions(c *check.C) {
+	_, err := NewPipeline().Plan()
+	c.Assert(err, check.ErrorMatches, "No actions to execute.")
+}
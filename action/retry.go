@@ -0,0 +1,109 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/libgo/cmd"
+)
+
+// RetryPolicy describes how many times, and how often, the Forward function
+// of an action is called again after a failure, before the pipeline gives up
+// and rolls back.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Values lower
+	// than 2 disable retries.
+	MaxAttempts int
+
+	// Delay before the second attempt. It doubles after each attempt.
+	Backoff time.Duration
+
+	// Upper bound for the delay between attempts. Zero means no bound.
+	MaxBackoff time.Duration
+
+	// Fraction (between 0 and 1) of each delay that is randomized, so
+	// pipelines failing together do not retry in lockstep.
+	Jitter float64
+
+	// Function that decides whether an error is worth another attempt.
+	// When nil, every error is retried.
+	Retryable func(error) bool
+}
+
+func (rp *RetryPolicy) attempts() int {
+	if rp == nil || rp.MaxAttempts < 1 {
+		return 1
+	}
+	return rp.MaxAttempts
+}
+
+// retryable tells whether the error of an attempt is worth another one. An
+// attempt interrupted by its own Timeout is, while the pipeline context
+// is not done.
+func (rp *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if _, ok := err.(*InterruptedError); ok && ctx.Err() != nil {
+		return false
+	}
+	return rp.Retryable == nil || rp.Retryable(err)
+}
+
+// delay returns the time to wait after the given (1-based) attempt failed.
+func (rp *RetryPolicy) delay(attempt int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempt && d > 0 && d < math.MaxInt64/2; i++ {
+		if rp.MaxBackoff > 0 && d >= rp.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	if rp.Jitter > 0 && d > 0 {
+		jitter := rp.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+	return d
+}
+
+// run calls the Forward function of the action until it succeeds or its
+// retry policy gives up, returning the error of the last attempt.
+func (a *Action) run(step int, fwCtx FWContext) (Result, error) {
+	attempts := a.Retry.attempts()
+	for attempt := 1; ; attempt++ {
+		r, err := a.forward(step, fwCtx)
+		if err == nil || attempt >= attempts || !a.Retry.retryable(fwCtx.Context, err) {
+			return r, err
+		}
+		wait := a.Retry.delay(attempt)
+		log.Debugf(cmd.Colorfy(fmt.Sprintf("  => step %d: %s action attempt %d/%d error - %s (retry in %s)", step, a.Name, attempt, attempts, err, wait), "yellow", "", ""))
+		select {
+		case <-time.After(wait):
+		case <-fwCtx.Context.Done():
+			return nil, &InterruptedError{Step: step, Action: a.Name, Err: fwCtx.Context.Err()}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package action
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRetrySucceedsAfterFailures(c *check.C) {
+	var calls, onErrorCalls int
+	myAction := Action{
+		Forward: func(ctx FWContext) (Result, error) {
+			calls++
+			if calls < 3 {
+				return nil, errors.New("transient")
+			}
+			return "ok", nil
+		},
+		OnError: func(ctx FWContext, err error) {
+			onErrorCalls++
+		},
+		Retry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	}
+	pipeline := NewPipeline(&myAction)
+	err := pipeline.Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 3)
+	c.Assert(onErrorCalls, check.Equals, 0)
+	c.Assert(pipeline.Result(), check.Equals, "ok")
+}
+
+func (s *S) TestRetryGivesUp(c *check.C) {
+	var calls, onErrorCalls int
+	var rolledBack bool
+	returnedErr := errors.New("still failing")
+	actions := []*Action{
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				return "ok", nil
+			},
+			Backward: func(ctx BWContext) {
+				rolledBack = true
+			},
+		},
+		{
+			Forward: func(ctx FWContext) (Result, error) {
+				calls++
+				return nil, returnedErr
+			},
+			OnError: func(ctx FWContext, err error) {
+				onErrorCalls++
+				c.Assert(err, check.Equals, returnedErr)
+			},
+			Retry: &RetryPolicy{MaxAttempts: 4},
+		},
+	}
+	pipeline := NewPipeline(actions...)
+	err := pipeline.Execute()
+	c.Assert(err, check.Equals, returnedErr)
+	c.Assert(calls, check.Equals, 4)
+	c.Assert(onErrorCalls, check.Equals, 1)
+	c.Assert(rolledBack, check.Equals, true)
+}
+
+func (s *S) TestRetryNotRetryable(c *check.C) {
+	var calls int
+	fatal := errors.New("fatal")
+	myAction := Action{
+		Forward: func(ctx FWContext) (Result, error) {
+			calls++
+			return nil, fatal
+		},
+		Retry: &RetryPolicy{
+			MaxAttempts: 5,
+			Retryable: func(err error) bool {
+				return err != fatal
+			},
+		},
+	}
+	pipeline := NewPipeline(&myAction)
+	err := pipeline.Execute()
+	c.Assert(err, check.Equals, fatal)
+	c.Assert(calls, check.Equals, 1)
+}
+
+func (s *S) TestRetryInterruptedWhileWaiting(c *check.C) {
+	var calls int
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	myAction := Action{
+		Name: "flaky",
+		Forward: func(ctx FWContext) (Result, error) {
+			calls++
+			return nil, errors.New("transient")
+		},
+		Retry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Hour},
+	}
+	pipeline := NewPipeline(&myAction)
+	err := pipeline.ExecuteContext(ctx)
+	c.Assert(err, check.DeepEquals, &InterruptedError{Step: 0, Action: "flaky", Err: context.DeadlineExceeded})
+	c.Assert(calls, check.Equals, 1)
+}
+
+func (s *S) TestRetryAttemptTimeout(c *check.C) {
+	var calls int
+	myAction := Action{
+		Name:    "hung",
+		Timeout: 10 * time.Millisecond,
+		Forward: func(ctx FWContext) (Result, error) {
+			calls++
+			if calls < 3 {
+				<-ctx.Context.Done()
+				return nil, ctx.Context.Err()
+			}
+			return "ok", nil
+		},
+		Retry: &RetryPolicy{MaxAttempts: 3},
+	}
+	pipeline := NewPipeline(&myAction)
+	err := pipeline.Execute()
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 3)
+	c.Assert(pipeline.Result(), check.Equals, "ok")
+}
+
+func (s *S) TestRetryPolicyDelay(c *check.C) {
+	rp := &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	c.Assert(rp.delay(1), check.Equals, 100*time.Millisecond)
+	c.Assert(rp.delay(2), check.Equals, 200*time.Millisecond)
+	c.Assert(rp.delay(4), check.Equals, 800*time.Millisecond)
+	c.Assert(rp.delay(5), check.Equals, time.Second)
+	c.Assert(rp.delay(100), check.Equals, time.Second)
+}
+
+func (s *S) TestRetryPolicyDelayJitter(c *check.C) {
+	rp := &RetryPolicy{Backoff: 100 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 20; i++ {
+		d := rp.delay(1)
+		c.Assert(d <= 100*time.Millisecond, check.Equals, true)
+		c.Assert(d >= 50*time.Millisecond, check.Equals, true)
+	}
+}
+
+func (s *S) TestRetryPolicyAttempts(c *check.C) {
+	var rp *RetryPolicy
+	c.Assert(rp.attempts(), check.Equals, 1)
+	c.Assert((&RetryPolicy{}).attempts(), check.Equals, 1)
+	c.Assert((&RetryPolicy{MaxAttempts: 3}).attempts(), check.Equals, 3)
+}
+
+func (s *S) TestRetryPolicyDelayDoesNotOverflow(c *check.C) {
+	rp := &RetryPolicy{Backoff: time.Second}
+	c.Assert(rp.delay(200) > 0, check.Equals, true)
+}
@@ -1,12 +1,13 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"reflect"
 	"strings"
 	"io/ioutil"
+	"time"
 	"github.com/megamsys/libgo/utils"
 	log "github.com/Sirupsen/logrus"
 )
@@ -14,7 +15,12 @@ const (
 	DELETE = "DELETE"
 	POST = "POST"
 	GET = "GET"
-	UPDATE = "UPDATE"
+	PUT = "PUT"
+	PATCH = "PATCH"
+	HEAD = "HEAD"
+
+	// Deprecated: UPDATE is not an http method, use PUT.
+	UPDATE = PUT
 )
 type VerticeApi interface {
 	ToMap() map[string]string
@@ -61,44 +67,163 @@ func (c ApiArgs) ToMap() map[string]string {
 }
 
 func (c *Client) Get() ([]byte, error) {
-		log.Debugf("Request [GET] ==> " + c.Url)
-	return c.run(GET)
+	return c.GetCtx(context.Background())
+}
+
+// GetCtx is like Get, bounded by the given context.
+func (c *Client) GetCtx(ctx context.Context) ([]byte, error) {
+	return c.DoCtx(ctx, GET, c.Authly.UrlSuffix, nil)
 }
 
 func (c *Client) Post(data interface{}) ([]byte, error) {
-	jsonbody, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
-	c.Authly.JSONBody = jsonbody
-	log.Debugf("Request [POST] ==> " + c.Url)
-	log.Debugf("[Body]  (%s)",string(jsonbody))
- return c.run(POST)
+	return c.PostCtx(context.Background(), data)
+}
+
+// PostCtx is like Post, bounded by the given context.
+func (c *Client) PostCtx(ctx context.Context, data interface{}) ([]byte, error) {
+	return c.DoCtx(ctx, POST, c.Authly.UrlSuffix, data)
+}
+
+func (c *Client) Put(data interface{}) ([]byte, error) {
+	return c.PutCtx(context.Background(), data)
+}
+
+// PutCtx is like Put, bounded by the given context.
+func (c *Client) PutCtx(ctx context.Context, data interface{}) ([]byte, error) {
+	return c.DoCtx(ctx, PUT, c.Authly.UrlSuffix, data)
+}
+
+func (c *Client) Patch(data interface{}) ([]byte, error) {
+	return c.PatchCtx(context.Background(), data)
+}
+
+// PatchCtx is like Patch, bounded by the given context.
+func (c *Client) PatchCtx(ctx context.Context, data interface{}) ([]byte, error) {
+	return c.DoCtx(ctx, PATCH, c.Authly.UrlSuffix, data)
 }
 
 func (c *Client) Delete() ([]byte, error) {
-	log.Debugf("Request [DELETE] ==> " + c.Url)
- return c.run(DELETE)
+	return c.DeleteCtx(context.Background())
 }
 
-func (c *Client) run(method string) ([]byte, error) {
-		err := c.Authly.AuthHeader()
-		if err != nil {
+// DeleteCtx is like Delete, bounded by the given context.
+func (c *Client) DeleteCtx(ctx context.Context) ([]byte, error) {
+	return c.DoCtx(ctx, DELETE, c.Authly.UrlSuffix, nil)
+}
+
+// Head returns the headers of the response to a HEAD request.
+func (c *Client) Head() (http.Header, error) {
+	return c.HeadCtx(context.Background())
+}
+
+// HeadCtx is like Head, bounded by the given context.
+func (c *Client) HeadCtx(ctx context.Context) (http.Header, error) {
+	header, _, err := c.run(ctx, HEAD, c.Authly.UrlSuffix, nil)
+	return header, err
+}
+
+// Do sends a request with the given method to the given path on the
+// gateway, with data as its JSON body (none when nil), and returns the
+// response body. The body, date and signature belong to the request, so a
+// Client may be used by several goroutines at once.
+func (c *Client) Do(method, path string, data interface{}) ([]byte, error) {
+	return c.DoCtx(context.Background(), method, path, data)
+}
+
+// DoCtx is like Do, bounded by the given context.
+func (c *Client) DoCtx(ctx context.Context, method, path string, data interface{}) ([]byte, error) {
+	var body []byte
+	if data != nil {
+		var err error
+		if body, err = json.Marshal(data); err != nil {
 			return nil, err
 		}
-		request, err := http.NewRequest(method, c.Url, bytes.NewReader(c.Authly.JSONBody))
+	}
+	_, result, err := c.run(ctx, method, path, body)
+	return result, err
+}
+
+// run signs and sends the request, retrying it according to the retry
+// policy of the client. The response body is always read and closed, so
+// that the connection goes back to the pool.
+func (c *Client) run(ctx context.Context, method, path string, body []byte) (http.Header, []byte, error) {
+	if len(body) > 0 {
+		log.Debugf("[Body]  (%s)", string(body))
+	}
+	response, err := c.send(ctx, c.HTTPClient, method, path, bytesPayload(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	return response.Header, result, err
+}
+
+// send signs and sends the request with the given http client, retrying it
+// according to the retry policy of the client. The caller must close the
+// body of the response.
+func (c *Client) send(ctx context.Context, hc *http.Client, method, path string, p *payload) (*http.Response, error) {
+	auth, err := c.authly()
+	if err != nil {
+		return nil, err
+	}
+	url := auth.URLFor(path)
+	host := hostOf(url)
+	log.Debugf("Request [%s] ==> %s", method, url)
+	attempts := c.Retry.attempts(method)
+	for attempt := 1; ; attempt++ {
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		headers, err := auth.SignDigest(time.Now().UTC().Format(time.RFC850), path, p.digest)
 		if err != nil {
 			return nil, err
 		}
-		response, err := c.Do(request)
+		body, err := p.open()
 		if err != nil {
 			return nil, err
 		}
-
-		jsonData, err := ioutil.ReadAll(response.Body)
+		request, err := http.NewRequest(method, url, body)
 		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		request.ContentLength = p.size
+		request.GetBody = p.open
+		for headerKey, headerVal := range headers {
+			request.Header.Set(headerKey, headerVal)
+		}
+		if c.VersionHeader != "" {
+			request.Header.Set(c.VersionHeader, c.Version)
+		}
+		if c.Breaker != nil {
+			if err := c.Breaker.allow(host); err != nil {
+				body.Close()
+				return nil, err
+			}
+		}
+		response, err := c.sendWith(hc, request.WithContext(ctx))
+		if c.Breaker != nil {
+			if err != nil && ctx.Err() != nil {
+				c.Breaker.release(host)
+			} else {
+				c.Breaker.record(host, err != nil && (response == nil || response.StatusCode >= 500))
+			}
+		}
+		if err == nil {
+			return response, nil
+		}
+		if attempt >= attempts || !retryable(ctx, response) || (c.Breaker != nil && c.Breaker.State(host) == BreakerOpen) {
 			return nil, err
 		}
-		defer response.Body.Close()
-		return jsonData, nil
+		delay := c.Retry.delay(attempt)
+		log.Debugf("Request [%s] ==> %s failed (attempt %d of %d), retrying in %s: %s", method, url, attempt, attempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
 }
@@ -0,0 +1,183 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Interaction is an exchange with the gateway kept in a cassette.
+type Interaction struct {
+	Method   string      `json:"method"`
+	URI      string      `json:"uri"`
+	Request  string      `json:"request,omitempty"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header,omitempty"`
+	Response string      `json:"response"`
+}
+
+// Cassette is a list of interactions, stored as a JSON file.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Save writes the cassette to a file.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Recorder is a server that forwards the requests to a real gateway,
+// unchanged so their signatures stay valid, and records the exchanges.
+// Point the clients to its URL with the path of the gateway url, for
+// instance Recorder.URL + "/v2".
+type Recorder struct {
+	*httptest.Server
+
+	// Path of the cassette written by Close.
+	Path string
+
+	target    string
+	transport http.RoundTripper
+	mu        sync.Mutex
+	cassette  Cassette
+}
+
+// NewRecorder starts a Recorder forwarding to the gateway at target (its
+// scheme and host), saving the cassette to path when closed.
+func NewRecorder(target, path string) *Recorder {
+	r := &Recorder{Path: path, target: strings.TrimRight(target, "/"), transport: http.DefaultTransport}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *Recorder) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	out, err := http.NewRequest(req.Method, r.target+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	out.Header = req.Header
+	response, err := r.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+	data, _ := ioutil.ReadAll(response.Body)
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Method:   req.Method,
+		URI:      req.URL.RequestURI(),
+		Request:  string(body),
+		Status:   response.StatusCode,
+		Header:   http.Header{"Content-Type": response.Header["Content-Type"]},
+		Response: string(data),
+	})
+	r.mu.Unlock()
+	for k, v := range response.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write(data)
+}
+
+// Close stops the server and saves the cassette.
+func (r *Recorder) Close() error {
+	r.Server.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(r.Path)
+}
+
+// Replayer is a server that answers with the interactions of a cassette.
+// A request gets the first unused interaction with the same method and
+// uri, so repeated requests are answered in the recorded order. Bodies and
+// signatures are not compared, as they carry the request date.
+type Replayer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewReplayer starts a Replayer of the given cassette file.
+func NewReplayer(path string) (*Replayer, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r, nil
+}
+
+func (r *Replayer) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || in.Method != req.Method || in.URI != req.URL.RequestURI() {
+			continue
+		}
+		r.used[i] = true
+		for k, v := range in.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(in.Status)
+		w.Write([]byte(in.Response))
+		return
+	}
+	http.Error(w, fmt.Sprintf("no recorded interaction for %s %s", req.Method, req.URL.RequestURI()), http.StatusNotImplemented)
+}
+
+// Unused returns the interactions that were not replayed.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unused []Interaction
+	for i, in := range r.cassette.Interactions {
+		if !r.used[i] {
+			unused = append(unused, in)
+		}
+	}
+	return unused
+}
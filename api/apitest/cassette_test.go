@@ -0,0 +1,72 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package apitest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/megamsys/libgo/api"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRecordAndReplay(c *check.C) {
+	dir, err := ioutil.TempDir("", "cassette")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "balances.json")
+	gateway := NewServer()
+	defer gateway.Close()
+	gateway.AddAccount("info@megam.io", "key")
+	gateway.Put("balances", balance{Id: "BAL1", AccountId: "info@megam.io", Credit: "10"})
+
+	recorder := NewRecorder(gateway.URL, path)
+	args := gateway.Args("info@megam.io")
+	args.Url = recorder.URL
+	g := api.NewGateway(args)
+	var b balance
+	c.Assert(g.Balances.Get("info@megam.io", &b), check.IsNil)
+	c.Assert(g.Balances.Update(balance{Id: "BAL1", AccountId: "info@megam.io", Credit: "3"}), check.IsNil)
+	c.Assert(g.Balances.Get("info@megam.io", &b), check.IsNil)
+	c.Assert(b.Credit, check.Equals, "3")
+	c.Assert(recorder.Close(), check.IsNil)
+
+	cassette, err := LoadCassette(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(cassette.Interactions, check.HasLen, 3)
+	c.Assert(cassette.Interactions[1].Method, check.Equals, "POST")
+	c.Assert(cassette.Interactions[1].URI, check.Equals, "/balances/update")
+
+	replayer, err := NewReplayer(path)
+	c.Assert(err, check.IsNil)
+	defer replayer.Close()
+	args.Url = replayer.URL
+	g = api.NewGateway(args)
+	c.Assert(g.Balances.Get("info@megam.io", &b), check.IsNil)
+	c.Assert(b.Credit, check.Equals, "10")
+	c.Assert(g.Balances.Update(balance{Id: "BAL1", AccountId: "info@megam.io", Credit: "3"}), check.IsNil)
+	c.Assert(replayer.Unused(), check.HasLen, 1)
+	c.Assert(g.Balances.Get("info@megam.io", &b), check.IsNil)
+	c.Assert(b.Credit, check.Equals, "3")
+	c.Assert(replayer.Unused(), check.HasLen, 0)
+
+	g.Client.Retry = nil
+	_, err = g.Client.Do(api.GET, "/accounts/info@megam.io", nil)
+	c.Assert(api.StatusCode(err), check.Equals, http.StatusNotImplemented)
+}
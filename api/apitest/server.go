@@ -0,0 +1,245 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+// Package apitest provides a fake Vertice gateway for testing code that
+// calls it through api.Client, and a cassette mode that records exchanges
+// with a real gateway and replays them.
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/megamsys/libgo/api"
+)
+
+// Item is a resource stored by the Server, as decoded from its JSON.
+type Item map[string]interface{}
+
+// keyFields are the fields matched by GET /<resource>/<key>, besides id.
+var keyFields = map[string]string{
+	"accounts": "email",
+	"balances": "account_id",
+	"addons":   "provider_name",
+}
+
+// Server emulates the gateway endpoints used in libgo, keeping the
+// resources in memory:
+//
+//	GET  /<resource>              lists the items
+//	GET  /<resource>/<key>        the items whose id (or key field) matches
+//	POST /<resource>/content      stores a new item
+//	POST /<resource>/<anything>   updates the item with the same id
+//
+// Every request must be signed by an account added with AddAccount.
+type Server struct {
+	*httptest.Server
+
+	// MasterKey accepted for requests in master key mode.
+	MasterKey string
+
+	mu        sync.Mutex
+	accounts  map[string]string
+	resources map[string][]Item
+}
+
+// NewServer starts a fake gateway. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		accounts:  make(map[string]string),
+		resources: make(map[string][]Item),
+	}
+	v := api.NewVerifier(s)
+	v.Replays = nil
+	s.Server = httptest.NewServer(v.Middleware(http.HandlerFunc(s.serve)))
+	return s
+}
+
+// AddAccount registers an account allowed to call the server.
+func (s *Server) AddAccount(email, apiKey string) {
+	s.mu.Lock()
+	s.accounts[email] = apiKey
+	s.mu.Unlock()
+}
+
+// Args returns the ApiArgs of a registered account, pointing at the
+// server.
+func (s *Server) Args(email string) api.ApiArgs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return api.ApiArgs{Email: email, Api_Key: s.accounts[email], Url: s.URL}
+}
+
+// Map returns the credentials of a registered account as the meta map
+// taken by api.NewArgs.
+func (s *Server) Map(email string) map[string]string {
+	return s.Args(email).ToMap()
+}
+
+// Key implements api.KeyStore.
+func (s *Server) Key(email, org string, mode api.KeyMode) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.accounts[email]
+	switch {
+	case !ok:
+		return "", api.ErrUnknownKey
+	case mode == api.PasswordMode:
+		return email, nil
+	case mode == api.MasterKeyMode:
+		if s.MasterKey == "" {
+			return "", api.ErrUnknownKey
+		}
+		return s.MasterKey, nil
+	}
+	return key, nil
+}
+
+// Put stores items of the given resource, such as "balances". The items
+// are converted through JSON.
+func (s *Server) Put(resource string, items ...interface{}) error {
+	for _, i := range items {
+		data, err := json.Marshal(i)
+		if err != nil {
+			return err
+		}
+		var item Item
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.store(resource, item)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Items returns the items of the given resource, in the order they were
+// stored.
+func (s *Server) Items(resource string) []Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Item(nil), s.resources[resource]...)
+}
+
+// store adds the item, replacing the one with the same id.
+func (s *Server) store(resource string, item Item) {
+	if id, ok := item["id"]; ok && id != "" {
+		for i, old := range s.resources[resource] {
+			if old["id"] == id {
+				s.resources[resource][i] = item
+				return
+			}
+		}
+	}
+	s.resources[resource] = append(s.resources[resource], item)
+}
+
+func (s *Server) find(resource, key string) []Item {
+	field := keyFields[resource]
+	var found []Item
+	for _, item := range s.resources[resource] {
+		if item["id"] == key || (field != "" && item[field] == key) {
+			found = append(found, item)
+		}
+	}
+	return found
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+	resource, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case api.GET:
+		items := s.resources[resource]
+		if key != "" {
+			items = s.find(resource, key)
+			if len(items) == 0 {
+				s.error(w, http.StatusNotFound, fmt.Sprintf("%s %s not found.", resource, key))
+				return
+			}
+		}
+		items, err := page(items, r.URL.Query())
+		if err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set(api.Content_Type, "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"json_claz": "Megam::" + strings.Title(resource) + "Collection",
+			"results":   items,
+		})
+	case api.POST:
+		var item Item
+		data, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &item); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.store(resource, item)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"code":201,"msg_type":"info","msg":"%s saved."}`, resource)
+	default:
+		s.error(w, http.StatusMethodNotAllowed, r.Method+" is not supported.")
+	}
+}
+
+// page returns the items in the window of the limit and offset of the
+// query, as the gateway lists them.
+func page(items []Item, query url.Values) ([]Item, error) {
+	var window [2]int
+	for i, name := range []string{"offset", "limit"} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid %s %q.", name, v)
+		}
+		window[i] = n
+	}
+	offset, limit := window[0], window[1]
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	if items == nil {
+		items = []Item{}
+	}
+	return items, nil
+}
+
+func (s *Server) error(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(api.Content_Type, "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(api.GatewayError{Code: code, MsgType: "error", Msg: msg})
+}
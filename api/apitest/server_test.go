@@ -0,0 +1,107 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package apitest
+
+import (
+	"net/http"
+
+	"github.com/megamsys/libgo/api"
+	"gopkg.in/check.v1"
+)
+
+type balance struct {
+	Id        string `json:"id"`
+	AccountId string `json:"account_id"`
+	Credit    string `json:"credit"`
+}
+
+func (s *S) TestServerGetAndUpdate(c *check.C) {
+	server := NewServer()
+	defer server.Close()
+	server.AddAccount("info@megam.io", "key")
+	err := server.Put("balances", balance{Id: "BAL1", AccountId: "info@megam.io", Credit: "10"})
+	c.Assert(err, check.IsNil)
+	g := api.NewGateway(server.Args("info@megam.io"))
+	var b balance
+	c.Assert(g.Balances.Get("info@megam.io", &b), check.IsNil)
+	c.Assert(b, check.Equals, balance{Id: "BAL1", AccountId: "info@megam.io", Credit: "10"})
+	b.Credit = "4"
+	_, err = api.NewClient(api.NewArgs(server.Map("info@megam.io")), "/balances/bill").Post(b)
+	c.Assert(err, check.IsNil)
+	c.Assert(server.Items("balances"), check.DeepEquals, []Item{
+		{"id": "BAL1", "account_id": "info@megam.io", "credit": "4"},
+	})
+	err = g.Balances.Get("nobody@megam.io", &b)
+	c.Assert(api.IsNotFound(err), check.Equals, true)
+}
+
+func (s *S) TestServerCreate(c *check.C) {
+	server := NewServer()
+	defer server.Close()
+	server.AddAccount("info@megam.io", "key")
+	cl := api.NewClient(server.Args("info@megam.io"), "/eventsvm/content")
+	for i := 0; i < 2; i++ {
+		_, err := cl.Post(map[string]string{"event_type": "compute.instance.launched"})
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(server.Items("eventsvm"), check.HasLen, 2)
+	var list []map[string]string
+	c.Assert(api.NewGateway(server.Args("info@megam.io")).Events.Vm.List(nil, &list), check.IsNil)
+	c.Assert(list, check.HasLen, 2)
+}
+
+func (s *S) TestServerListWindow(c *check.C) {
+	server := NewServer()
+	defer server.Close()
+	server.AddAccount("info@megam.io", "key")
+	for _, id := range []string{"BAL1", "BAL2", "BAL3", "BAL4"} {
+		c.Assert(server.Put("balances", balance{Id: id}), check.IsNil)
+	}
+	g := api.NewGateway(server.Args("info@megam.io"))
+	ids := func(opts *api.ListOptions) []string {
+		var list []balance
+		c.Assert(g.Balances.List(opts, &list), check.IsNil)
+		ids := []string{}
+		for _, b := range list {
+			ids = append(ids, b.Id)
+		}
+		return ids
+	}
+	c.Assert(ids(&api.ListOptions{Limit: 2}), check.DeepEquals, []string{"BAL1", "BAL2"})
+	c.Assert(ids(&api.ListOptions{Limit: 2, Offset: 1}), check.DeepEquals, []string{"BAL2", "BAL3"})
+	c.Assert(ids(&api.ListOptions{Offset: 3}), check.DeepEquals, []string{"BAL4"})
+	c.Assert(ids(&api.ListOptions{Offset: 5}), check.DeepEquals, []string{})
+	_, err := api.NewClient(server.Args("info@megam.io"), "/balances?limit=x").Get()
+	c.Assert(api.StatusCode(err), check.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestServerRejectsUnsignedRequests(c *check.C) {
+	server := NewServer()
+	defer server.Close()
+	server.AddAccount("info@megam.io", "key")
+	args := server.Args("info@megam.io")
+	args.Api_Key = "wrong"
+	_, err := api.NewClient(args, "/balances/info@megam.io").Get()
+	c.Assert(api.StatusCode(err), check.Equals, http.StatusUnauthorized)
+	args = server.Args("info@megam.io")
+	args.Api_Key, args.Master_Key = "", "master"
+	_, err = api.NewClient(args, "/balances").Get()
+	c.Assert(api.StatusCode(err), check.Equals, http.StatusUnauthorized)
+	server.MasterKey = "master"
+	_, err = api.NewClient(args, "/balances").Get()
+	c.Assert(err, check.IsNil)
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
   "net/url"
 	"strings"
@@ -17,7 +19,7 @@ type Authly struct {
 func NewAuthly(c VerticeApi) *Authly {
 	m := c.ToMap()
 	auth := &Authly{
-		Date:      time.Now().Format(time.RFC850),
+		Date:      time.Now().UTC().Format(time.RFC850),
 		UrlSuffix: m[PATH],
 		Keys:      m,
 		AuthMap:   map[string]string{},
@@ -31,18 +33,56 @@ func GetPort() string {
 }
 
 func (auth *Authly) GetURL() string {
-	return strings.TrimRight(auth.Keys[HOST], "/") + strings.TrimRight(auth.UrlSuffix, "/")
+	return auth.URLFor(auth.UrlSuffix)
 }
 
+// URLFor returns the url of the given path on the gateway.
+func (auth *Authly) URLFor(path string) string {
+	return strings.TrimRight(auth.Keys[HOST], "/") + strings.TrimRight(path, "/")
+}
+
+// AuthHeader signs the shared JSONBody and UrlSuffix of the Authly, storing
+// the headers in AuthMap. Client does not use it: it signs every request
+// with Sign instead, so that concurrent requests do not share state.
 func (authly *Authly) AuthHeader() error {
+	headMap, err := authly.Sign(authly.Date, authly.UrlSuffix, authly.JSONBody)
+	if err != nil {
+		return err
+	}
+	authly.AuthMap = headMap
+	return nil
+}
+
+// Sign returns the authentication headers of a request to the given path
+// (relative to the gateway url), dated and carrying the given body.
+func (authly *Authly) Sign(date, path string, body []byte) (map[string]string, error) {
+	return authly.SignDigest(date, path, GetMD5Hash(body))
+}
+
+// SignDigest is like Sign, for a body whose digest was computed with
+// GetMD5Hash or a BodyDigest.
+func (authly *Authly) SignDigest(date, path, md5Body string) (map[string]string, error) {
+	return authly.sign(date, path, md5Body, "")
+}
+
+// SignNonce is like SignDigest, also signing a new X-Megam-NONCE so that
+// identical requests sent within the same second are not taken for replays
+// by a Verifier. Only servers checking with a Verifier accept it.
+func (authly *Authly) SignNonce(date, path, md5Body string) (map[string]string, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	return authly.sign(date, path, md5Body, nonce)
+}
+
+func (authly *Authly) sign(date, path, md5Body, nonce string) (map[string]string, error) {
 	headMap := make(map[string]string)
 	key := ""
-  v, err := url.Parse(authly.Keys[HOST])
-    if err != nil {
-        return err
-    }
-	timeStampedPath := authly.Date + "\n" + v.Path + authly.UrlSuffix
-	md5Body := GetMD5Hash(authly.JSONBody)
+	v, err := url.Parse(authly.Keys[HOST])
+	if err != nil {
+		return nil, err
+	}
 	switch true {
 	case (authly.Keys[API_KEY] != ""):
 		key = authly.Keys[API_KEY]
@@ -55,11 +95,32 @@ func (authly *Authly) AuthHeader() error {
 	}
 
 	headMap[X_Megam_ORG] = authly.Keys[ORG_ID]
-	headMap[X_Megam_DATE] = authly.Date
+	headMap[X_Megam_DATE] = date
 	headMap[X_Megam_EMAIL] = authly.Keys[EMAIL]
+	if nonce != "" {
+		headMap[X_Megam_NONCE] = nonce
+	}
 	headMap[Accept] = application_vnd_megam_json
-	headMap[X_Megam_HMAC] = authly.Keys[EMAIL] + ":" + CalcHMAC(key, (timeStampedPath+"\n"+md5Body))
+	headMap[X_Megam_HMAC] = authly.Keys[EMAIL] + ":" + CalcHMAC(key, signedMessage(date, v.Path+path, md5Body, nonce))
 	headMap["Content-Type"] = "application/json"
-	authly.AuthMap = headMap
-	return nil
+	return headMap, nil
+}
+
+// signedMessage returns the message signed for a request to the given
+// path, the one of the gateway url followed by the path of the request.
+// The nonce, when there is one, is appended to the message of the gateway.
+func signedMessage(date, path, md5Body, nonce string) string {
+	message := date + "\n" + path + "\n" + md5Body
+	if nonce != "" {
+		message += "\n" + nonce
+	}
+	return message
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,289 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megamsys/libgo/hc"
+)
+
+// ErrCircuitOpen is returned by Client, without sending the request, while
+// the circuit breaker of the gateway host is open.
+var ErrCircuitOpen = errors.New("Circuit breaker is open, the gateway is unavailable.")
+
+// RateLimiter is a token bucket limiting the requests of the clients that
+// share it.
+type RateLimiter struct {
+	// Requests allowed per second, on average. Zero or less means no
+	// limit.
+	Rate float64
+
+	// Requests allowed at once after an idle period.
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing rate requests per second,
+// in bursts of up to burst requests.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{Rate: rate, Burst: burst, tokens: float64(burst)}
+}
+
+func (l *RateLimiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// reserve takes a token if one is available, and otherwise tells how long
+// to wait for the next one.
+func (l *RateLimiter) reserve() time.Duration {
+	if l.Rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.Rate
+		if l.tokens > float64(l.Burst) {
+			l.tokens = float64(l.Burst)
+		}
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.Rate * float64(time.Second))
+}
+
+// Wait blocks until a request is allowed or the context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		d := l.reserve()
+		if d == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// BreakerState is the state of the circuit breaker of a host.
+type BreakerState int
+
+const (
+	// Requests are sent.
+	BreakerClosed BreakerState = iota
+
+	// Requests fail with ErrCircuitOpen.
+	BreakerOpen
+
+	// Probe requests are sent, one at a time, to test the host.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker is a circuit breaker, keeping a state for each gateway host. A
+// request fails when it gets a network error or a 5xx response.
+type Breaker struct {
+	// Consecutive failures that open the circuit.
+	FailureThreshold int
+
+	// How long the circuit stays open before probing the host.
+	OpenTimeout time.Duration
+
+	// Consecutive successful probes that close the circuit.
+	SuccessThreshold int
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+	now   func() time.Time
+}
+
+type circuit struct {
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	probing   bool
+}
+
+// NewBreaker creates a Breaker opening after failures consecutive failures
+// and probing the host after timeout.
+func NewBreaker(failures int, timeout time.Duration) *Breaker {
+	return &Breaker{FailureThreshold: failures, OpenTimeout: timeout, SuccessThreshold: 1}
+}
+
+func (b *Breaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func (b *Breaker) circuit(host string) *circuit {
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+// allow tells whether a request to the host may be sent.
+func (b *Breaker) allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(host)
+	if c.state == BreakerOpen && b.clock().Sub(c.openedAt) >= b.OpenTimeout {
+		c.state, c.successes, c.probing = BreakerHalfOpen, 0, false
+	}
+	switch c.state {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if c.probing {
+			return ErrCircuitOpen
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+// record updates the circuit of the host with the outcome of a request.
+func (b *Breaker) record(host string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuit(host)
+	c.probing = false
+	if failed {
+		c.failures++
+		c.successes = 0
+		if c.state == BreakerHalfOpen || c.failures >= b.FailureThreshold {
+			c.state, c.openedAt = BreakerOpen, b.clock()
+		}
+		return
+	}
+	c.failures = 0
+	if c.state == BreakerHalfOpen {
+		c.successes++
+		if c.successes >= b.SuccessThreshold {
+			c.state = BreakerClosed
+		}
+	}
+}
+
+// release ends a request to the host that was cancelled by its context,
+// counting it neither as a failure nor as a success.
+func (b *Breaker) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.circuit(host).probing = false
+}
+
+// State returns the state of the circuit of the given host.
+func (b *Breaker) State(host string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.hosts[host]
+	if !ok {
+		return BreakerClosed
+	}
+	if c.state == BreakerOpen && b.clock().Sub(c.openedAt) >= b.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+// States returns the state of the circuit of every host seen so far.
+func (b *Breaker) States() map[string]BreakerState {
+	b.mu.Lock()
+	hosts := make([]string, 0, len(b.hosts))
+	for h := range b.hosts {
+		hosts = append(hosts, h)
+	}
+	b.mu.Unlock()
+	states := make(map[string]BreakerState, len(hosts))
+	for _, h := range hosts {
+		states[h] = b.State(h)
+	}
+	return states
+}
+
+// Check is a health checker failing while the circuit of some host is
+// open. Its raw result maps the hosts to their states.
+func (b *Breaker) Check() (interface{}, error) {
+	raw := make(map[string]string)
+	var open []string
+	for h, s := range b.States() {
+		raw[h] = s.String()
+		if s == BreakerOpen {
+			open = append(open, h)
+		}
+	}
+	if len(open) > 0 {
+		sort.Strings(open)
+		return raw, fmt.Errorf("circuit open for %s", strings.Join(open, ", "))
+	}
+	return raw, nil
+}
+
+// Register adds the breaker to the health checkers, under the given name.
+func (b *Breaker) Register(name string) {
+	hc.AddChecker(name, b.Check)
+}
+
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	return u.Host
+}
@@ -0,0 +1,168 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megamsys/libgo/hc"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRateLimiter(c *check.C) {
+	now := time.Now()
+	l := NewRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+	c.Assert(l.reserve(), check.Equals, time.Duration(0))
+	c.Assert(l.reserve(), check.Equals, time.Duration(0))
+	c.Assert(l.reserve(), check.Equals, 500*time.Millisecond)
+	now = now.Add(500 * time.Millisecond)
+	c.Assert(l.reserve(), check.Equals, time.Duration(0))
+	now = now.Add(time.Hour)
+	c.Assert(l.reserve(), check.Equals, time.Duration(0))
+	c.Assert(l.reserve(), check.Equals, time.Duration(0))
+	c.Assert(l.reserve() > 0, check.Equals, true)
+}
+
+func (s *S) TestRateLimiterWaitCancelled(c *check.C) {
+	l := NewRateLimiter(0.001, 1)
+	c.Assert(l.Wait(context.Background()), check.IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(l.Wait(ctx), check.Equals, context.DeadlineExceeded)
+}
+
+func (s *S) TestRateLimiterUnlimited(c *check.C) {
+	l := NewRateLimiter(0, 1)
+	for i := 0; i < 3; i++ {
+		c.Assert(l.reserve(), check.Equals, time.Duration(0))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Assert(l.Wait(ctx), check.IsNil)
+}
+
+func (s *S) TestBreakerStates(c *check.C) {
+	now := time.Now()
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	c.Assert(b.allow("gw"), check.IsNil)
+	b.record("gw", true)
+	c.Assert(b.State("gw"), check.Equals, BreakerClosed)
+	c.Assert(b.allow("gw"), check.IsNil)
+	b.record("gw", true)
+	c.Assert(b.State("gw"), check.Equals, BreakerOpen)
+	c.Assert(b.allow("gw"), check.Equals, ErrCircuitOpen)
+	c.Assert(b.allow("other"), check.IsNil)
+	now = now.Add(time.Minute)
+	c.Assert(b.State("gw"), check.Equals, BreakerHalfOpen)
+	c.Assert(b.allow("gw"), check.IsNil)
+	c.Assert(b.allow("gw"), check.Equals, ErrCircuitOpen)
+	b.record("gw", true)
+	c.Assert(b.State("gw"), check.Equals, BreakerOpen)
+	now = now.Add(time.Minute)
+	c.Assert(b.allow("gw"), check.IsNil)
+	b.record("gw", false)
+	c.Assert(b.State("gw"), check.Equals, BreakerClosed)
+	c.Assert(BreakerHalfOpen.String(), check.Equals, "half-open")
+}
+
+func (s *S) TestClientBreaker(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	cl := s.newTestClient(server.URL, "/assembly/ASM1")
+	cl.Breaker = NewBreaker(2, time.Hour)
+	_, err := cl.Get()
+	c.Assert(StatusCode(err), check.Equals, http.StatusServiceUnavailable)
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(2))
+	_, err = cl.Get()
+	c.Assert(err, check.Equals, ErrCircuitOpen)
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(2))
+	cl.Breaker.Register("gateway")
+	var result *hc.Result
+	for _, r := range hc.Check() {
+		if r.Name == "gateway" {
+			result = &r
+		}
+	}
+	c.Assert(result, check.NotNil)
+	host := strings.TrimPrefix(server.URL, "http://")
+	c.Assert(result.Status, check.Equals, "fail - circuit open for "+host)
+	c.Assert(result.Raw, check.DeepEquals, map[string]string{host: "open"})
+}
+
+func (s *S) TestClientErrorsDoNotOpenBreaker(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	cl := s.newTestClient(server.URL, "/assembly/ASM1")
+	cl.Breaker = NewBreaker(1, time.Hour)
+	for i := 0; i < 3; i++ {
+		_, err := cl.Get()
+		c.Assert(StatusCode(err), check.Equals, http.StatusNotFound)
+	}
+	raw, err := cl.Breaker.Check()
+	c.Assert(err, check.IsNil)
+	c.Assert(raw, check.DeepEquals, map[string]string{strings.TrimPrefix(server.URL, "http://"): "closed"})
+}
+
+func (s *S) TestClientCancelledRequestsDoNotCount(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-r.Context().Done()
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	now := time.Now()
+	cl := s.newTestClient(server.URL, "/assembly/ASM1")
+	cl.Retry = nil
+	cl.Breaker = NewBreaker(2, time.Minute)
+	cl.Breaker.now = func() time.Time { return now }
+	host := strings.TrimPrefix(server.URL, "http://")
+	slow := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := cl.DoCtx(ctx, GET, "/slow", nil)
+		c.Assert(err, check.NotNil)
+	}
+	_, err := cl.Do(GET, "/down", nil)
+	c.Assert(StatusCode(err), check.Equals, http.StatusServiceUnavailable)
+	slow()
+	c.Assert(cl.Breaker.State(host), check.Equals, BreakerClosed)
+	_, err = cl.Do(GET, "/down", nil)
+	c.Assert(StatusCode(err), check.Equals, http.StatusServiceUnavailable)
+	c.Assert(cl.Breaker.State(host), check.Equals, BreakerOpen)
+	now = now.Add(time.Minute)
+	slow()
+	c.Assert(cl.Breaker.State(host), check.Equals, BreakerHalfOpen)
+	_, err = cl.Do(GET, "/ok", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(cl.Breaker.State(host), check.Equals, BreakerClosed)
+}
@@ -1,26 +1,58 @@
 package api
 
 import (
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 )
 
+// DefaultTimeout bounds every request of the clients created by NewClient,
+// including reading the response body. Change HTTPClient.Timeout to use
+// another value for a single client.
+var DefaultTimeout = 30 * time.Second
+
+// transport is shared by the clients created by NewClient, so that
+// connections to the gateway are kept alive and reused across clients.
+var transport = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
 type Context struct {
 
 }
 
 type Client struct {
 	HTTPClient     *http.Client
+	Retry          *RetryPolicy
+	Limiter        *RateLimiter
+	Breaker        *Breaker
+	Credentials    CredentialProvider
 	context        *Context
 	Authly         *Authly
 	Url            string
 	progname       string
-	currentVersion string
 	versionHeader  string
+
+	// Version of the client, compared with the version the gateway
+	// supports.
+	Version string
+
+	// Request header carrying Version, none when empty.
+	VersionHeader string
+
+	// Called when the gateway requires a newer version. The warning is
+	// logged when nil.
+	OnVersionWarning func(*VersionWarning)
+
+	// Fails the requests with the *VersionWarning when the major version
+	// required by the gateway differs.
+	StrictVersion bool
 }
 
 
@@ -28,91 +60,75 @@ func NewClient(c VerticeApi, path string) *Client {
 	auth := NewAuthly(c)
 	auth.UrlSuffix = path
 	return  &Client{
-		HTTPClient:     &http.Client{},
+		HTTPClient:     &http.Client{Timeout: DefaultTimeout, Transport: transport},
+		Retry:          DefaultRetry,
 		context:        &Context{},
 		Authly:         auth,
 		Url:            auth.GetURL(),
 		progname:       "Vertice-Go-api",
-		currentVersion: "2",
+		Version:        "2",
 		versionHeader:  "Supported-Gulp",
 	}
 }
 
-func (c *Client) detectClientError(err error) error {
-	return fmt.Errorf("Failed to connect to api server.")
+// NewClientWithCredentials creates a Client that resolves its credentials
+// through the given provider on every request.
+func NewClientWithCredentials(p CredentialProvider, path string) *Client {
+	cl := NewClient(ApiArgs{}, path)
+	cl.Credentials = p
+	return cl
 }
 
-func (c *Client) Do(request *http.Request) (*http.Response, error) {
-	for headerKey, headerVal := range c.Authly.AuthMap {
-	  request.Header.Add(headerKey, headerVal)
-  }
-	request.Close = true
-	response, err := c.HTTPClient.Do(request)
-
+// authly returns the Authly signing the next request: the one of the
+// client, or a new one built from the provider credentials.
+func (c *Client) authly() (*Authly, error) {
+	if c.Credentials == nil {
+		return c.Authly, nil
+	}
+	args, err := c.Credentials.Credentials()
 	if err != nil {
 		return nil, err
 	}
-	supported := response.Header.Get(c.versionHeader)
-	format := `################################################################
+	auth := NewAuthly(args)
+	auth.UrlSuffix = c.Authly.UrlSuffix
+	return auth, nil
+}
 
-WARNING: You're using an unsupported version of %s.
+func (c *Client) detectClientError(err error) error {
+	return fmt.Errorf("Failed to connect to api server.")
+}
 
-You must have at least version %s, your current
-version is %s.
+// Send sends a request already signed with Authly.Sign. When the response
+// status is not 2xx, the body is consumed and an *APIError is returned
+// along with the response. When StrictVersion is set and the gateway
+// requires another major version, the *VersionWarning is returned instead.
+func (c *Client) Send(request *http.Request) (*http.Response, error) {
+	return c.sendWith(c.HTTPClient, request)
+}
 
-################################################################
+func (c *Client) sendWith(hc *http.Client, request *http.Request) (*http.Response, error) {
+	response, err := hc.Do(request)
 
-`
-	if !validateVersion(supported, c.currentVersion) {
-		fmt.Println(format)
-		fmt.Println(supported)
+	if err != nil {
+		return nil, err
+	}
+	if w := checkVersion(response.Header.Get(c.versionHeader), c.Version); w != nil {
+		if w.Incompatible && c.StrictVersion {
+			response.Body.Close()
+			return response, w
+		}
+		if c.OnVersionWarning != nil {
+			c.OnVersionWarning(w)
+		} else {
+			log.Warnf("%s: %s", c.progname, w)
+		}
 	}
-	if response.StatusCode > 399 {
+	if response.StatusCode < 200 || response.StatusCode > 299 {
 		defer response.Body.Close()
 		result, _ := ioutil.ReadAll(response.Body)
-		return response, errors.New(string(result))
+		return response, newAPIError(request.Method, request.URL.String(), response.StatusCode, result)
 	}
   //defer response.Body.Close()
 	return response, nil
 
 }
-
-// validateVersion checks whether current version is greater or equal to
-// supported version.
-func validateVersion(supported, current string) bool {
-	var (
-		bigger bool
-		limit  int
-	)
-	if supported == "" {
-		return true
-	}
-	partsSupported := strings.Split(supported, ".")
-	partsCurrent := strings.Split(current, ".")
-	if len(partsSupported) > len(partsCurrent) {
-		limit = len(partsCurrent)
-		bigger = true
-	} else {
-		limit = len(partsSupported)
-	}
-	for i := 0; i < limit; i++ {
-		current, err := strconv.Atoi(partsCurrent[i])
-		if err != nil {
-			return false
-		}
-		supported, err := strconv.Atoi(partsSupported[i])
-		if err != nil {
-			return false
-		}
-		if current < supported {
-			return false
-		}
-		if current > supported {
-			return true
-		}
-	}
-	if bigger {
-		return false
-	}
-	return true
-}
@@ -1,10 +1,167 @@
 package api
 
 import (
-   "fmt"
-  	"gopkg.in/check.v1"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/check.v1"
 )
+
 func (s *S) TestBindService(c *check.C) {
-  err := fmt.Errorf("error")
-  c.Assert(err, check.NotNil)
+	err := fmt.Errorf("error")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) newTestClient(url, path string) *Client {
+	args := s.ApiArgs
+	args.Url = url
+	cl := NewClient(args, path)
+	cl.Retry = &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	return cl
+}
+
+func (s *S) TestGetRetriesServerErrors(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		c.Check(r.Close, check.Equals, false)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+	data, err := s.newTestClient(server.URL, "/assembly/ASM1").Get()
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `{"ok":true}`)
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(3))
+}
+
+func (s *S) TestGetStopsAfterMaxAttempts(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	}))
+	defer server.Close()
+	_, err := s.newTestClient(server.URL, "/assembly/ASM1").Get()
+	c.Assert(err, check.ErrorMatches, "GET .*/assembly/ASM1: 500 boom")
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(3))
+}
+
+func (s *S) TestPostIsNotRetried(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	_, err := s.newTestClient(server.URL, "/assembly/update").Post(map[string]string{"id": "ASM1"})
+	c.Assert(err, check.NotNil)
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(1))
+}
+
+func (s *S) TestClientErrorsAreNotRetried(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	_, err := s.newTestClient(server.URL, "/assembly/ASM1").Delete()
+	c.Assert(err, check.NotNil)
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(1))
+}
+
+func (s *S) TestGetCtxCancelled(c *check.C) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.newTestClient(server.URL, "/assembly/ASM1").GetCtx(ctx)
+	c.Assert(err, check.NotNil)
+	c.Assert(ctx.Err(), check.Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+}
+
+func (s *S) TestRetryPolicyDelay(c *check.C) {
+	r := &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	c.Assert(r.delay(1), check.Equals, 100*time.Millisecond)
+	c.Assert(r.delay(2), check.Equals, 200*time.Millisecond)
+	c.Assert(r.delay(3), check.Equals, 300*time.Millisecond)
+	c.Assert(r.delay(50), check.Equals, 300*time.Millisecond)
+}
+
+func (s *S) TestRetryPolicyAttempts(c *check.C) {
+	var r *RetryPolicy
+	c.Assert(r.attempts(GET), check.Equals, 1)
+	r = &RetryPolicy{MaxAttempts: 4}
+	c.Assert(r.attempts(GET), check.Equals, 4)
+	c.Assert(r.attempts(DELETE), check.Equals, 4)
+	c.Assert(r.attempts(POST), check.Equals, 1)
+}
+
+func (s *S) TestVerbs(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		if r.Method != HEAD {
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+		}
+	}))
+	defer server.Close()
+	cl := s.newTestClient(server.URL, "/assembly/ASM1")
+	data, err := cl.Put(map[string]string{"status": "running"})
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `PUT /assembly/ASM1 {"status":"running"}`)
+	data, err = cl.Patch(map[string]string{"status": "stopped"})
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `PATCH /assembly/ASM1 {"status":"stopped"}`)
+	header, err := cl.Head()
+	c.Assert(err, check.IsNil)
+	c.Assert(header.Get("X-Method"), check.Equals, HEAD)
+	data, err = cl.Do(POST, "/components/update", map[string]string{"id": "CMP1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `POST /components/update {"id":"CMP1"}`)
+}
+
+func (s *S) TestConcurrentRequestsAreSignedSeparately(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		args := s.ApiArgs
+		args.Url = "http://" + r.Host
+		expected, err := NewAuthly(args).Sign(r.Header.Get(X_Megam_DATE), r.URL.Path, body)
+		if err != nil || r.Header.Get(X_Megam_HMAC) != expected[X_Megam_HMAC] {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	cl := s.newTestClient(server.URL, "/assembly")
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cl.Do(POST, fmt.Sprintf("/assembly/ASM%d", i), map[string]int{"id": i})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, check.IsNil)
+	}
 }
@@ -0,0 +1,133 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megamsys/libgo/cmd"
+	"gopkg.in/yaml.v2"
+)
+
+// CredentialProvider resolves the credentials of a Client. Clients created
+// with NewClientWithCredentials call it on every request.
+type CredentialProvider interface {
+	Credentials() (ApiArgs, error)
+}
+
+// StaticCredentials always provides the same credentials.
+type StaticCredentials ApiArgs
+
+func (s StaticCredentials) Credentials() (ApiArgs, error) {
+	return ApiArgs(s), nil
+}
+
+// EnvCredentials reads the credentials from the environment variables
+// named after the keys of NewArgs, upper cased and prefixed: with the
+// default prefix, MEGAM_EMAIL, MEGAM_API_KEY, MEGAM_MASTER_KEY,
+// MEGAM_PASSWORD, MEGAM_ORG_ID and MEGAM_URL.
+type EnvCredentials struct {
+	// Prefix of the variables, "MEGAM_" when empty.
+	Prefix string
+}
+
+func (e EnvCredentials) Credentials() (ApiArgs, error) {
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "MEGAM_"
+	}
+	m := make(map[string]string)
+	for _, key := range []string{EMAIL, API_KEY, MASTER_KEY, PASSWORD, ORG_ID, HOST} {
+		m[key] = os.Getenv(prefix + strings.ToUpper(key))
+	}
+	return NewArgs(m), nil
+}
+
+// DefaultCredentialsFile is the credentials file read by FileCredentials
+// when it has no Path.
+func DefaultCredentialsFile() string {
+	return cmd.JoinWithUserDir(".megam", "credentials")
+}
+
+// FileCredentials reads the credentials from a YAML file holding the keys
+// of NewArgs, such as:
+//
+//	email: info@megam.io
+//	api_key: 0a1b2c
+//	url: https://api.megam.io/v2
+type FileCredentials struct {
+	// Path of the file, DefaultCredentialsFile() when empty.
+	Path string
+}
+
+func (f FileCredentials) Credentials() (ApiArgs, error) {
+	path := f.Path
+	if path == "" {
+		path = DefaultCredentialsFile()
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ApiArgs{}, err
+	}
+	m := make(map[string]string)
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return ApiArgs{}, err
+	}
+	return NewArgs(m), nil
+}
+
+// RefreshingCredentials caches the credentials of another provider for a
+// while, so that rotated keys are picked up without calling the provider on
+// every request.
+type RefreshingCredentials struct {
+	Provider CredentialProvider
+
+	// How long the credentials are cached. Zero means until Expire is
+	// called.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	args    ApiArgs
+	fetched time.Time
+	valid   bool
+}
+
+func (r *RefreshingCredentials) Credentials() (ApiArgs, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.valid && (r.TTL == 0 || time.Since(r.fetched) < r.TTL) {
+		return r.args, nil
+	}
+	args, err := r.Provider.Credentials()
+	if err != nil {
+		return ApiArgs{}, err
+	}
+	r.args, r.fetched, r.valid = args, time.Now(), true
+	return args, nil
+}
+
+// Expire drops the cached credentials, so that the next request fetches
+// them again.
+func (r *RefreshingCredentials) Expire() {
+	r.mu.Lock()
+	r.valid = false
+	r.mu.Unlock()
+}
@@ -0,0 +1,117 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestStaticCredentials(c *check.C) {
+	args, err := StaticCredentials{Email: "info@megam.io", Api_Key: "key"}.Credentials()
+	c.Assert(err, check.IsNil)
+	c.Assert(args, check.Equals, ApiArgs{Email: "info@megam.io", Api_Key: "key"})
+}
+
+func (s *S) TestEnvCredentials(c *check.C) {
+	os.Setenv("TEST_MEGAM_EMAIL", "info@megam.io")
+	os.Setenv("TEST_MEGAM_API_KEY", "key")
+	os.Setenv("TEST_MEGAM_URL", "http://localhost:9000/v2")
+	defer func() {
+		os.Unsetenv("TEST_MEGAM_EMAIL")
+		os.Unsetenv("TEST_MEGAM_API_KEY")
+		os.Unsetenv("TEST_MEGAM_URL")
+	}()
+	args, err := EnvCredentials{Prefix: "TEST_MEGAM_"}.Credentials()
+	c.Assert(err, check.IsNil)
+	c.Assert(args, check.Equals, ApiArgs{Email: "info@megam.io", Api_Key: "key", Url: "http://localhost:9000/v2"})
+}
+
+func (s *S) TestFileCredentials(c *check.C) {
+	dir, err := ioutil.TempDir("", "credentials")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	err = ioutil.WriteFile(path, []byte("email: info@megam.io\nmaster_key: secret\norg_id: ORG1\n"), 0600)
+	c.Assert(err, check.IsNil)
+	args, err := FileCredentials{Path: path}.Credentials()
+	c.Assert(err, check.IsNil)
+	c.Assert(args, check.Equals, ApiArgs{Email: "info@megam.io", Master_Key: "secret", Org_Id: "ORG1"})
+	_, err = FileCredentials{Path: filepath.Join(dir, "missing")}.Credentials()
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *S) TestDefaultCredentialsFile(c *check.C) {
+	c.Assert(filepath.Base(DefaultCredentialsFile()), check.Equals, "credentials")
+	c.Assert(filepath.Base(filepath.Dir(DefaultCredentialsFile())), check.Equals, ".megam")
+}
+
+type countingProvider struct {
+	calls int
+	err   error
+}
+
+func (p *countingProvider) Credentials() (ApiArgs, error) {
+	p.calls++
+	return ApiArgs{Email: "info@megam.io"}, p.err
+}
+
+func (s *S) TestRefreshingCredentials(c *check.C) {
+	p := &countingProvider{}
+	r := &RefreshingCredentials{Provider: p, TTL: time.Hour}
+	for i := 0; i < 3; i++ {
+		args, err := r.Credentials()
+		c.Assert(err, check.IsNil)
+		c.Assert(args.Email, check.Equals, "info@megam.io")
+	}
+	c.Assert(p.calls, check.Equals, 1)
+	r.Expire()
+	p.err = errors.New("vault is sealed")
+	_, err := r.Credentials()
+	c.Assert(err, check.ErrorMatches, "vault is sealed")
+	c.Assert(p.calls, check.Equals, 2)
+	r.TTL = time.Nanosecond
+	p.err = nil
+	r.Credentials()
+	time.Sleep(time.Millisecond)
+	r.Credentials()
+	c.Assert(p.calls, check.Equals, 4)
+}
+
+func (s *S) TestClientResolvesCredentialsPerRequest(c *check.C) {
+	var emails []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		emails = append(emails, r.Header.Get(X_Megam_EMAIL))
+	}))
+	defer server.Close()
+	r := &RefreshingCredentials{Provider: StaticCredentials{Email: "one@megam.io", Api_Key: "k", Url: server.URL}}
+	cl := NewClientWithCredentials(r, "/accounts")
+	_, err := cl.Get()
+	c.Assert(err, check.IsNil)
+	r.Provider = StaticCredentials{Email: "two@megam.io", Api_Key: "k", Url: server.URL}
+	r.Expire()
+	_, err = cl.Get()
+	c.Assert(err, check.IsNil)
+	c.Assert(emails, check.DeepEquals, []string{"one@megam.io", "two@megam.io"})
+}
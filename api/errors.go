@@ -0,0 +1,91 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	liberrors "github.com/megamsys/libgo/errors"
+)
+
+// GatewayError is the error body returned by the gateway.
+type GatewayError struct {
+	Code     int    `json:"code"`
+	MsgType  string `json:"msg_type"`
+	Msg      string `json:"msg"`
+	More     string `json:"more"`
+	Links    string `json:"links"`
+	JsonClaz string `json:"json_claz"`
+}
+
+// APIError is returned by Client for every response whose status is not
+// 2xx. It unwraps to an *errors.HTTP carrying the same status code, so
+// callers can check either type with errors.As.
+type APIError struct {
+	// Method and URL of the request.
+	Method string
+	URL    string
+
+	// Status code of the response.
+	StatusCode int
+
+	// Raw response body.
+	Body []byte
+
+	// Parsed response body, nil when the body is not a gateway error.
+	Gateway *GatewayError
+}
+
+func newAPIError(method, url string, code int, body []byte) *APIError {
+	e := &APIError{Method: method, URL: url, StatusCode: code, Body: body}
+	var g GatewayError
+	if err := json.Unmarshal(body, &g); err == nil && (g.Msg != "" || g.Code != 0) {
+		e.Gateway = &g
+	}
+	return e
+}
+
+// Message returns the message of the gateway error, or the raw body when
+// it could not be parsed.
+func (e *APIError) Message() string {
+	if e.Gateway != nil && e.Gateway.Msg != "" {
+		return e.Gateway.Msg
+	}
+	return strings.TrimSpace(string(e.Body))
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message())
+}
+
+func (e *APIError) Unwrap() error {
+	return &liberrors.HTTP{Code: e.StatusCode, Message: e.Message()}
+}
+
+// StatusCode returns the status code of an *APIError or *errors.HTTP, and
+// zero for any other error.
+func StatusCode(err error) int {
+	switch e := err.(type) {
+	case *APIError:
+		return e.StatusCode
+	case *liberrors.HTTP:
+		return e.Code
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+
+	liberrors "github.com/megamsys/libgo/errors"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAPIErrorFromGateway(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":404,"msg_type":"error","msg":"Assembly ASM1 not found.","more":"","links":"","json_claz":"Megam::Error"}`))
+	}))
+	defer server.Close()
+	_, err := s.newTestClient(server.URL, "/assembly/ASM1").Get()
+	var apiErr *APIError
+	c.Assert(stderrors.As(err, &apiErr), check.Equals, true)
+	c.Assert(apiErr.Method, check.Equals, "GET")
+	c.Assert(apiErr.URL, check.Equals, server.URL+"/assembly/ASM1")
+	c.Assert(apiErr.StatusCode, check.Equals, http.StatusNotFound)
+	c.Assert(apiErr.Gateway, check.NotNil)
+	c.Assert(apiErr.Gateway.Msg, check.Equals, "Assembly ASM1 not found.")
+	c.Assert(err.Error(), check.Equals, "GET "+server.URL+"/assembly/ASM1: 404 Assembly ASM1 not found.")
+	var httpErr *liberrors.HTTP
+	c.Assert(stderrors.As(err, &httpErr), check.Equals, true)
+	c.Assert(httpErr.Code, check.Equals, http.StatusNotFound)
+	c.Assert(StatusCode(err), check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestAPIErrorRawBody(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid signature\n"))
+	}))
+	defer server.Close()
+	_, err := s.newTestClient(server.URL, "/accounts/info@megam.io").Post(nil)
+	c.Assert(StatusCode(err), check.Equals, http.StatusUnauthorized)
+	apiErr := err.(*APIError)
+	c.Assert(apiErr.Gateway, check.IsNil)
+	c.Assert(apiErr.Message(), check.Equals, "invalid signature")
+}
+
+func (s *S) TestNonErrorStatusIsReported(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+	_, err := s.newTestClient(server.URL, "/assembly/ASM1").Get()
+	c.Assert(StatusCode(err), check.Equals, http.StatusNotModified)
+}
+
+func (s *S) TestStatusCodeOtherErrors(c *check.C) {
+	c.Assert(StatusCode(stderrors.New("boom")), check.Equals, 0)
+	c.Assert(StatusCode(&liberrors.HTTP{Code: 409}), check.Equals, 409)
+}
@@ -0,0 +1,209 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Gateway is a client of the resources of the Vertice gateway. It signs its
+// requests with the credentials of the ApiArgs it was created with and may
+// be used by several goroutines at once.
+type Gateway struct {
+	Client *Client
+
+	Accounts        *Service
+	Organizations   *Service
+	Balances        *Service
+	BilledHistories *Service
+	Addons          *Service
+	Events          *EventServices
+}
+
+// EventServices groups the services of the events posted to the gateway,
+// one for each kind of event.
+type EventServices struct {
+	Vm        *Service
+	Container *Service
+	Storage   *Service
+	Billing   *Service
+}
+
+// NewGateway creates a Gateway for the given credentials.
+func NewGateway(args VerticeApi) *Gateway {
+	cl := NewClient(args, "")
+	return &Gateway{
+		Client:          cl,
+		Accounts:        &Service{client: cl, Path: "/accounts"},
+		Organizations:   &Service{client: cl, Path: "/organizations"},
+		Balances:        &Service{client: cl, Path: "/balances"},
+		BilledHistories: &Service{client: cl, Path: "/billedhistories"},
+		Addons:          &Service{client: cl, Path: "/addons"},
+		Events: &EventServices{
+			Vm:        &Service{client: cl, Path: "/eventsvm"},
+			Container: &Service{client: cl, Path: "/eventscontainer"},
+			Storage:   &Service{client: cl, Path: "/eventsstorage"},
+			Billing:   &Service{client: cl, Path: "/eventsbilling"},
+		},
+	}
+}
+
+// NotFoundError is returned by Service.Get when the gateway does not know
+// the resource, either answering 404 or with no results.
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Resource %s not found.", e.Path)
+}
+
+// IsNotFound tells whether err is a *NotFoundError or a 404 response.
+func IsNotFound(err error) bool {
+	if _, ok := err.(*NotFoundError); ok {
+		return true
+	}
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// ListOptions selects a page of a List call. Zero values are left to the
+// gateway defaults.
+type ListOptions struct {
+	Limit  int
+	Offset int
+}
+
+func (o *ListOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	q := url.Values{}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// Service is a resource of the gateway, such as /accounts.
+type Service struct {
+	// Path of the resource, relative to the gateway url.
+	Path string
+
+	client *Client
+}
+
+// envelope is the body of the gateway responses. Results is either a list
+// of resources or a single one.
+type envelope struct {
+	JsonClaz string          `json:"json_claz"`
+	Results  json.RawMessage `json:"results"`
+}
+
+func (s *Service) get(ctx context.Context, path string) (json.RawMessage, error) {
+	data, err := s.client.DoCtx(ctx, GET, path, nil)
+	if err != nil {
+		if StatusCode(err) == http.StatusNotFound {
+			return nil, &NotFoundError{Path: path}
+		}
+		return nil, err
+	}
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	results := bytes.TrimSpace(e.Results)
+	if len(results) == 0 || bytes.Equal(results, []byte("null")) {
+		return nil, nil
+	}
+	if results[0] != '[' {
+		results = append(append([]byte("["), results...), ']')
+	}
+	return results, nil
+}
+
+// Get fetches the resource with the given id into v. It returns a
+// *NotFoundError when there is no such resource.
+func (s *Service) Get(id string, v interface{}) error {
+	return s.GetCtx(context.Background(), id, v)
+}
+
+// GetCtx is like Get, bounded by the given context.
+func (s *Service) GetCtx(ctx context.Context, id string, v interface{}) error {
+	path := strings.TrimRight(s.Path, "/") + "/" + id
+	results, err := s.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(results, &list); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return &NotFoundError{Path: path}
+	}
+	return json.Unmarshal(list[0], v)
+}
+
+// List fetches a page of the resources into v, which must be a pointer to
+// a slice. A page shorter than opts.Limit is the last one.
+func (s *Service) List(opts *ListOptions, v interface{}) error {
+	return s.ListCtx(context.Background(), opts, v)
+}
+
+// ListCtx is like List, bounded by the given context.
+func (s *Service) ListCtx(ctx context.Context, opts *ListOptions, v interface{}) error {
+	results, err := s.get(ctx, s.Path+opts.query())
+	if err != nil || results == nil {
+		return err
+	}
+	return json.Unmarshal(results, v)
+}
+
+// Create posts a new resource.
+func (s *Service) Create(v interface{}) error {
+	return s.CreateCtx(context.Background(), v)
+}
+
+// CreateCtx is like Create, bounded by the given context.
+func (s *Service) CreateCtx(ctx context.Context, v interface{}) error {
+	_, err := s.client.DoCtx(ctx, POST, s.Path+"/content", v)
+	return err
+}
+
+// Update posts the new state of a resource.
+func (s *Service) Update(v interface{}) error {
+	return s.UpdateCtx(context.Background(), v)
+}
+
+// UpdateCtx is like Update, bounded by the given context.
+func (s *Service) UpdateCtx(ctx context.Context, v interface{}) error {
+	_, err := s.client.DoCtx(ctx, POST, s.Path+"/update", v)
+	return err
+}
@@ -0,0 +1,105 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+type testBalance struct {
+	Id     string `json:"id"`
+	Credit string `json:"credit"`
+}
+
+func (s *S) newTestGateway(handler http.HandlerFunc) (*Gateway, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	args := s.ApiArgs
+	args.Url = server.URL
+	return NewGateway(args), server
+}
+
+func (s *S) TestGatewayGet(c *check.C) {
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/balances/info@megam.io")
+		w.Write([]byte(`{"json_claz":"Megam::BalancesCollection","results":[{"id":"BAL1","credit":"10.5"}]}`))
+	})
+	defer server.Close()
+	var b testBalance
+	err := g.Balances.Get("info@megam.io", &b)
+	c.Assert(err, check.IsNil)
+	c.Assert(b, check.Equals, testBalance{Id: "BAL1", Credit: "10.5"})
+}
+
+func (s *S) TestGatewayGetSingleResult(c *check.C) {
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"json_claz":"Megam::Balances","results":{"id":"BAL1","credit":"3"}}`))
+	})
+	defer server.Close()
+	var b testBalance
+	c.Assert(g.Balances.Get("info@megam.io", &b), check.IsNil)
+	c.Assert(b.Id, check.Equals, "BAL1")
+}
+
+func (s *S) TestGatewayGetNotFound(c *check.C) {
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/balances/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"json_claz":"Megam::BalancesCollection","results":[]}`))
+	})
+	defer server.Close()
+	var b testBalance
+	err := g.Balances.Get("empty", &b)
+	c.Assert(err, check.DeepEquals, &NotFoundError{Path: "/balances/empty"})
+	c.Assert(err, check.ErrorMatches, "Resource /balances/empty not found.")
+	c.Assert(IsNotFound(err), check.Equals, true)
+	err = g.Balances.Get("missing", &b)
+	c.Assert(IsNotFound(err), check.Equals, true)
+}
+
+func (s *S) TestGatewayList(c *check.C) {
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/balances")
+		c.Check(r.URL.RawQuery, check.Equals, "limit=2&offset=4")
+		w.Write([]byte(`{"results":[{"id":"BAL5"},{"id":"BAL6"}]}`))
+	})
+	defer server.Close()
+	var list []testBalance
+	err := g.Balances.List(&ListOptions{Limit: 2, Offset: 4}, &list)
+	c.Assert(err, check.IsNil)
+	c.Assert(list, check.DeepEquals, []testBalance{{Id: "BAL5"}, {Id: "BAL6"}})
+}
+
+func (s *S) TestGatewayCreateAndUpdate(c *check.C) {
+	var requests []string
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+	})
+	defer server.Close()
+	c.Assert(g.Events.Vm.Create(testBalance{Id: "EVT1"}), check.IsNil)
+	c.Assert(g.Balances.Update(testBalance{Id: "BAL1", Credit: "1"}), check.IsNil)
+	c.Assert(requests, check.DeepEquals, []string{
+		`POST /eventsvm/content {"id":"EVT1","credit":""}`,
+		`POST /balances/update {"id":"BAL1","credit":"1"}`,
+	})
+}
@@ -0,0 +1,80 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DefaultRetry is the retry policy of the clients created by NewClient.
+var DefaultRetry = &RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     200 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+// RetryPolicy tells how a Client retries its idempotent requests (GET and
+// DELETE) after a network error or a 5xx response. Other requests are
+// never retried, since the gateway may have applied them already.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Zero or one
+	// means no retries.
+	MaxAttempts int
+
+	// Delay before the first retry. It doubles on every following retry.
+	Backoff time.Duration
+
+	// Upper bound of the delay between retries. Zero means no bound.
+	MaxBackoff time.Duration
+}
+
+func (r *RetryPolicy) attempts(method string) int {
+	if r == nil || r.MaxAttempts < 1 || !idempotent(method) {
+		return 1
+	}
+	return r.MaxAttempts
+}
+
+func (r *RetryPolicy) delay(attempt int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempt && d > 0; i++ {
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+func idempotent(method string) bool {
+	return method == GET || method == DELETE
+}
+
+// retryable tells whether a failed request may be sent again: the context
+// must still be alive and the failure must be a network error (no response)
+// or a server error.
+func retryable(ctx context.Context, response *http.Response) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return response == nil || response.StatusCode >= 500
+}
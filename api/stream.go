@@ -0,0 +1,316 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// BodyDigest computes the digest of a body as GetMD5Hash does, from the
+// successive writes of its content.
+type BodyDigest struct {
+	h hash.Hash
+}
+
+func NewBodyDigest() *BodyDigest {
+	return &BodyDigest{h: md5.New()}
+}
+
+func (d *BodyDigest) Write(p []byte) (int, error) {
+	return d.h.Write(p)
+}
+
+func (d *BodyDigest) String() string {
+	return base64.URLEncoding.EncodeToString(d.h.Sum(nil))
+}
+
+// payload is the body of a request, which can be sent again on retries and
+// redirects.
+type payload struct {
+	digest string
+	size   int64
+
+	// open returns the body positioned at its start, to be closed by the
+	// transport.
+	open  func() (io.ReadCloser, error)
+	close func() error
+}
+
+func bytesPayload(body []byte) *payload {
+	return &payload{
+		digest: GetMD5Hash(body),
+		size:   int64(len(body)),
+		open:   func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(body)), nil },
+		close:  func() error { return nil },
+	}
+}
+
+// readerPayload digests the body without keeping it in memory. A seekable
+// body is read twice, any other body is spooled to a temporary file.
+func readerPayload(body io.Reader) (*payload, error) {
+	d := NewBodyDigest()
+	if rs, ok := body.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		size, err := io.Copy(d, rs)
+		if err != nil {
+			return nil, err
+		}
+		return &payload{
+			digest: d.String(),
+			size:   size,
+			open: func() (io.ReadCloser, error) {
+				_, err := rs.Seek(start, io.SeekStart)
+				return ioutil.NopCloser(io.LimitReader(rs, size)), err
+			},
+			close: func() error { return nil },
+		}, nil
+	}
+	f, err := ioutil.TempFile("", "libgo-api")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(io.MultiWriter(f, d), body)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	sp := &spool{name: f.Name()}
+	return &payload{digest: d.String(), size: size, open: sp.open, close: sp.close}, nil
+}
+
+// spool is the temporary file of a body, removed once the payload is
+// closed and the transport closed every body read from it.
+type spool struct {
+	name string
+
+	mu      sync.Mutex
+	readers int
+	closed  bool
+}
+
+func (sp *spool) open() (io.ReadCloser, error) {
+	f, err := os.Open(sp.name)
+	if err != nil {
+		return nil, err
+	}
+	sp.mu.Lock()
+	sp.readers++
+	sp.mu.Unlock()
+	return &spoolReader{File: f, spool: sp}, nil
+}
+
+func (sp *spool) close() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.closed = true
+	return sp.remove()
+}
+
+// remove deletes the file when it is no longer needed. sp.mu is held.
+func (sp *spool) remove() error {
+	if !sp.closed || sp.readers > 0 {
+		return nil
+	}
+	return os.Remove(sp.name)
+}
+
+type spoolReader struct {
+	*os.File
+	spool *spool
+	once  sync.Once
+}
+
+func (r *spoolReader) Close() error {
+	err := r.File.Close()
+	r.once.Do(func() {
+		r.spool.mu.Lock()
+		defer r.spool.mu.Unlock()
+		r.spool.readers--
+		if rerr := r.spool.remove(); err == nil {
+			err = rerr
+		}
+	})
+	return err
+}
+
+// Stream sends a request with the given method to the given path on the
+// gateway and returns the response body, which the caller must close. The
+// body of the request, if any, is streamed too: a seekable body (such as an
+// *os.File) is read twice, once for the signature, any other body is
+// spooled to a temporary file, removed once the transport is done with it.
+// Streams are bounded by the context only, not by HTTPClient.Timeout.
+func (c *Client) Stream(ctx context.Context, method, path string, body io.Reader) (io.ReadCloser, error) {
+	p := bytesPayload(nil)
+	if body != nil {
+		var err error
+		if p, err = readerPayload(body); err != nil {
+			return nil, err
+		}
+		defer p.close()
+	}
+	hc := *c.HTTPClient
+	hc.Timeout = 0
+	response, err := c.send(ctx, &hc, method, path, p)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// ResultIterator decodes the results of a gateway response one at a time,
+// without loading the whole list in memory. Like the envelope of Get and
+// List, a single result rather than a list is a list of one.
+type ResultIterator struct {
+	body   io.ReadCloser
+	dec    *json.Decoder
+	err    error
+	done   bool
+	single bool
+}
+
+func newResultIterator(body io.ReadCloser) *ResultIterator {
+	it := &ResultIterator{body: body, dec: json.NewDecoder(body)}
+	it.err = it.seekResults()
+	return it
+}
+
+// seekResults moves the decoder into the results list.
+func (it *ResultIterator) seekResults() error {
+	if err := it.expect(json.Delim('{')); err != nil {
+		return err
+	}
+	for it.dec.More() {
+		t, err := it.dec.Token()
+		if err != nil {
+			return err
+		}
+		if t == "results" {
+			return it.enterResults()
+		}
+		var skip json.RawMessage
+		if err := it.dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	it.done = true
+	return nil
+}
+
+// enterResults moves the decoder into the results, once their key is read.
+// The decoder can't tell the kind of the next value without consuming it,
+// so the results are peeked at and read with a new decoder.
+func (it *ResultIterator) enterResults() error {
+	r := bufio.NewReader(io.MultiReader(it.dec.Buffered(), it.body))
+	first, err := peekValue(r)
+	if err != nil {
+		return err
+	}
+	it.dec = json.NewDecoder(r)
+	switch first {
+	case '[':
+		return it.expect(json.Delim('['))
+	case 'n':
+		it.done = true
+	default:
+		it.single = true
+	}
+	return nil
+}
+
+// peekValue returns the first byte of the value following a key, without
+// reading it.
+func peekValue(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', ':':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
+
+func (it *ResultIterator) expect(delim json.Delim) error {
+	t, err := it.dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("invalid gateway response: expected %s, got %v", delim, t)
+	}
+	return nil
+}
+
+// Next decodes the next result into v. It returns false when there are no
+// more results or an error happened, see Err.
+func (it *ResultIterator) Next(v interface{}) bool {
+	if it.err != nil || it.done {
+		return false
+	}
+	if it.single {
+		it.done = true
+	} else if !it.dec.More() {
+		it.done = true
+		return false
+	}
+	if it.err = it.dec.Decode(v); it.err != nil {
+		return false
+	}
+	return true
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ResultIterator) Err() error {
+	return it.err
+}
+
+// Close releases the response body.
+func (it *ResultIterator) Close() error {
+	return it.body.Close()
+}
+
+// Iterate lists the resources like List, decoding them one at a time with
+// the returned iterator, which the caller must close.
+func (s *Service) Iterate(ctx context.Context, opts *ListOptions) (*ResultIterator, error) {
+	body, err := s.client.Stream(ctx, GET, s.Path+opts.query(), nil)
+	if err != nil {
+		if StatusCode(err) == http.StatusNotFound {
+			return nil, &NotFoundError{Path: s.Path}
+		}
+		return nil, err
+	}
+	return newResultIterator(body), nil
+}
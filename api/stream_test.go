@@ -0,0 +1,174 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestBodyDigest(c *check.C) {
+	d := NewBodyDigest()
+	io.WriteString(d, "hello ")
+	io.WriteString(d, "world")
+	c.Assert(d.String(), check.Equals, GetMD5Hash([]byte("hello world")))
+}
+
+func (s *S) TestReaderPayload(c *check.C) {
+	for _, body := range []io.Reader{
+		strings.NewReader("image bytes"),
+		io.MultiReader(strings.NewReader("image "), strings.NewReader("bytes")),
+	} {
+		p, err := readerPayload(body)
+		c.Assert(err, check.IsNil)
+		c.Assert(p.digest, check.Equals, GetMD5Hash([]byte("image bytes")))
+		c.Assert(p.size, check.Equals, int64(11))
+		for i := 0; i < 2; i++ {
+			r, err := p.open()
+			c.Assert(err, check.IsNil)
+			data, err := ioutil.ReadAll(r)
+			c.Assert(err, check.IsNil)
+			c.Assert(string(data), check.Equals, "image bytes")
+			c.Assert(r.Close(), check.IsNil)
+		}
+		c.Assert(p.close(), check.IsNil)
+	}
+}
+
+func (s *S) TestSpoolRemovedAfterLastReader(c *check.C) {
+	p, err := readerPayload(io.MultiReader(strings.NewReader("image bytes")))
+	c.Assert(err, check.IsNil)
+	r, err := p.open()
+	c.Assert(err, check.IsNil)
+	name := r.(*spoolReader).Name()
+	c.Assert(p.close(), check.IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "image bytes")
+	_, err = os.Stat(name)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Close(), check.IsNil)
+	c.Assert(r.Close(), check.NotNil)
+	_, err = os.Stat(name)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *S) TestStreamFollowsRedirects(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/images/old" {
+			http.Redirect(w, r, "/images/new", http.StatusTemporaryRedirect)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer server.Close()
+	cl := NewClient(ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: server.URL}, "")
+	body, err := cl.Stream(context.Background(), PUT, "/images/old", io.MultiReader(strings.NewReader("moved")))
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "moved")
+}
+
+func (s *S) TestStreamUploadAndDownload(c *check.C) {
+	server := httptest.NewServer(NewVerifier(testKeys).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})))
+	defer server.Close()
+	cl := NewClient(ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: server.URL}, "")
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 1000; i++ {
+			io.WriteString(pw, "0123456789")
+		}
+		pw.Close()
+	}()
+	body, err := cl.Stream(context.Background(), PUT, "/images/IMG1", pr)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.HasLen, 10000)
+	body, err = cl.Stream(context.Background(), POST, "/images/IMG2", strings.NewReader("seekable"))
+	c.Assert(err, check.IsNil)
+	data, _ = ioutil.ReadAll(body)
+	body.Close()
+	c.Assert(string(data), check.Equals, "seekable")
+}
+
+func (s *S) TestServiceIterate(c *check.C) {
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.RawQuery, check.Equals, "limit=3")
+		w.Write([]byte(`{"json_claz":"Megam::BalancesCollection","extra":{"a":[1,2]},"results":[{"id":"BAL1"},{"id":"BAL2"},{"id":"BAL3"}]}`))
+	})
+	defer server.Close()
+	it, err := g.Balances.Iterate(context.Background(), &ListOptions{Limit: 3})
+	c.Assert(err, check.IsNil)
+	defer it.Close()
+	var ids []string
+	var b testBalance
+	for it.Next(&b) {
+		ids = append(ids, b.Id)
+	}
+	c.Assert(it.Err(), check.IsNil)
+	c.Assert(ids, check.DeepEquals, []string{"BAL1", "BAL2", "BAL3"})
+}
+
+func (s *S) TestServiceIterateSingleResult(c *check.C) {
+	body := `{"json_claz":"Megam::Balances","results" : {"id":"BAL1"}}`
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+	defer server.Close()
+	it, err := g.Balances.Iterate(context.Background(), nil)
+	c.Assert(err, check.IsNil)
+	var ids []string
+	var b testBalance
+	for it.Next(&b) {
+		ids = append(ids, b.Id)
+	}
+	it.Close()
+	c.Assert(it.Err(), check.IsNil)
+	c.Assert(ids, check.DeepEquals, []string{"BAL1"})
+	body = `{"json_claz":"Megam::Balances","results":null}`
+	it, err = g.Balances.Iterate(context.Background(), nil)
+	c.Assert(err, check.IsNil)
+	defer it.Close()
+	c.Assert(it.Next(&b), check.Equals, false)
+	c.Assert(it.Err(), check.IsNil)
+}
+
+func (s *S) TestServiceIterateInvalidResponse(c *check.C) {
+	g, server := s.newTestGateway(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":"BAL1"}]`))
+	})
+	defer server.Close()
+	it, err := g.Balances.Iterate(context.Background(), nil)
+	c.Assert(err, check.IsNil)
+	defer it.Close()
+	var b testBalance
+	c.Assert(it.Next(&b), check.Equals, false)
+	c.Assert(it.Err(), check.ErrorMatches, "invalid gateway response: expected \\{, got \\[")
+}
@@ -25,6 +25,7 @@ const (
 	X_Megam_HMAC               = "X-Megam-HMAC"
 	X_Megam_OTTAI              = "X-Megam-OTTAI"
 	X_Megam_ORG                = "X-Megam-ORG"
+	X_Megam_NONCE              = "X-Megam-NONCE"
 	Content_Type               = "Content-Type"
 	Accept                     = "Accept"
 	application_vnd_megam_json = "application/vnd.megam+json"
@@ -0,0 +1,286 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissingSignature = errors.New("Request is not signed.")
+	ErrInvalidSignature = errors.New("Invalid request signature.")
+	ErrRequestExpired   = errors.New("Request date is outside of the allowed window.")
+	ErrReplayedRequest  = errors.New("Request was already received.")
+	ErrMalformedRequest = errors.New("Malformed request date.")
+	ErrBodyTooLarge     = errors.New("Request body is too large.")
+
+	// ErrUnknownKey is returned by a KeyStore that knows no key for the
+	// account.
+	ErrUnknownKey = errors.New("Unknown account.")
+)
+
+// DefaultMaxSkew is the clock skew allowed by the verifiers created by
+// NewVerifier.
+var DefaultMaxSkew = 15 * time.Minute
+
+// DefaultMaxBodySize is the size of the largest body read by the verifiers
+// without MaxBodySize.
+const DefaultMaxBodySize = 10 << 20
+
+// KeyMode is the kind of key a request was signed with.
+type KeyMode string
+
+const (
+	ApiKeyMode    KeyMode = API_KEY
+	PasswordMode  KeyMode = PASSWORD
+	MasterKeyMode KeyMode = MASTER_KEY
+)
+
+// KeyStore looks up the key a request was signed with. For PasswordMode,
+// libgo clients sign with the email of the account.
+type KeyStore interface {
+	Key(email, org string, mode KeyMode) (string, error)
+}
+
+// ReplayCache remembers the signatures of the requests already received.
+type ReplayCache interface {
+	// Seen records the signature until the given time and tells whether
+	// it was already recorded.
+	Seen(signature string, until time.Time) bool
+}
+
+// Identity is the account a verified request was signed by.
+type Identity struct {
+	Email string
+	Org   string
+	Mode  KeyMode
+}
+
+// Verifier checks the X-Megam-HMAC signature of the requests, as produced
+// by Authly.
+type Verifier struct {
+	Keys KeyStore
+
+	// Allowed difference between X-Megam-DATE and the local clock.
+	MaxSkew time.Duration
+
+	// Cache of the received signatures. Only unsafe methods are checked
+	// for replays: X-Megam-DATE has a resolution of one second, so
+	// identical reads of clients signing without X-Megam-NONCE share a
+	// signature within the same second.
+	Replays ReplayCache
+
+	// Size of the largest body read to check the signature, larger ones
+	// being rejected. Zero means DefaultMaxBodySize.
+	MaxBodySize int64
+
+	now func() time.Time
+}
+
+// NewVerifier creates a Verifier looking keys up in the given store, with
+// an in-memory replay cache.
+func NewVerifier(keys KeyStore) *Verifier {
+	return &Verifier{
+		Keys:    keys,
+		MaxSkew: DefaultMaxSkew,
+		Replays: NewMemoryReplayCache(),
+	}
+}
+
+func (v *Verifier) clock() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+// Verify checks the signature of the request and returns the identity that
+// signed it. The request body is read and replaced, so handlers can still
+// read it, bodies larger than MaxBodySize being rejected.
+func (v *Verifier) Verify(r *http.Request) (*Identity, error) {
+	return v.verify(nil, r)
+}
+
+// verify is Verify, w being told when the body is too large.
+func (v *Verifier) verify(w http.ResponseWriter, r *http.Request) (*Identity, error) {
+	date := r.Header.Get(X_Megam_DATE)
+	parts := strings.SplitN(r.Header.Get(X_Megam_HMAC), ":", 2)
+	if date == "" || len(parts) != 2 || parts[1] == "" {
+		return nil, ErrMissingSignature
+	}
+	signedAt, err := time.Parse(time.RFC850, date)
+	if err != nil {
+		return nil, ErrMalformedRequest
+	}
+	if skew := v.clock().Sub(signedAt); skew > v.MaxSkew || -skew > v.MaxSkew {
+		return nil, ErrRequestExpired
+	}
+	id := &Identity{Email: parts[0], Org: r.Header.Get(X_Megam_ORG), Mode: ApiKeyMode}
+	switch {
+	case r.Header.Get(X_Megam_PUTTUSAVI) == "true":
+		id.Mode = PasswordMode
+	case r.Header.Get(X_Megam_MASTERKEY) == "true":
+		id.Mode = MasterKeyMode
+	}
+	key, err := v.Keys.Key(id.Email, id.Org, id.Mode)
+	if err == ErrUnknownKey {
+		return nil, ErrInvalidSignature
+	}
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if r.Body != nil {
+		max := v.MaxBodySize
+		if max <= 0 {
+			max = DefaultMaxBodySize
+		}
+		body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, max))
+		if err != nil && int64(len(body)) == max {
+			return nil, ErrBodyTooLarge
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if !signedWith(key, parts[1], date, r.URL, GetMD5Hash(body), r.Header.Get(X_Megam_NONCE)) {
+		return nil, ErrInvalidSignature
+	}
+	if v.Replays != nil && !safeMethod(r.Method) && v.Replays.Seen(parts[1], signedAt.Add(v.MaxSkew)) {
+		return nil, ErrReplayedRequest
+	}
+	return id, nil
+}
+
+// signedWith tells whether signature is the one of the request to u. Its
+// path was signed before the trailing slash was trimmed from the url, so
+// both forms are tried.
+func signedWith(key, signature, date string, u *url.URL, md5Body, nonce string) bool {
+	path, query := u.EscapedPath(), ""
+	if u.RawQuery != "" {
+		query = "?" + u.RawQuery
+	}
+	for _, p := range []string{path, path + "/"} {
+		expected := CalcHMAC(key, signedMessage(date, p+query, md5Body, nonce))
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+func safeMethod(method string) bool {
+	return method == GET || method == HEAD
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity stored by Verifier.Middleware.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Middleware verifies the requests before passing them to next, which can
+// get the signer with IdentityFromContext. Rejected requests get a 401, a
+// 400 or a 413 with a gateway error body.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := v.verify(w, r)
+		if err != nil {
+			code := http.StatusUnauthorized
+			switch err {
+			case ErrMissingSignature, ErrInvalidSignature, ErrRequestExpired, ErrReplayedRequest:
+			case ErrMalformedRequest:
+				code = http.StatusBadRequest
+			case ErrBodyTooLarge:
+				code = http.StatusRequestEntityTooLarge
+			default:
+				code = http.StatusInternalServerError
+			}
+			w.Header().Set(Content_Type, "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(GatewayError{Code: code, MsgType: "error", Msg: err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// MemoryReplayCache is a ReplayCache kept in memory.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	expires replayHeap
+	now     func() time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time), now: time.Now}
+}
+
+func (c *MemoryReplayCache) Seen(signature string, until time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for len(c.expires) > 0 && now.After(c.expires[0].until) {
+		e := heap.Pop(&c.expires).(replayEntry)
+		if t, ok := c.seen[e.signature]; ok && t.Equal(e.until) {
+			delete(c.seen, e.signature)
+		}
+	}
+	if t, ok := c.seen[signature]; ok && !now.After(t) {
+		return true
+	}
+	c.seen[signature] = until
+	heap.Push(&c.expires, replayEntry{signature, until})
+	return false
+}
+
+type replayEntry struct {
+	signature string
+	until     time.Time
+}
+
+// replayHeap orders the signatures of a MemoryReplayCache by expiry, so
+// only the expired ones are looked at.
+type replayHeap []replayEntry
+
+func (h replayHeap) Len() int            { return len(h) }
+func (h replayHeap) Less(i, j int) bool  { return h[i].until.Before(h[j].until) }
+func (h replayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *replayHeap) Push(x interface{}) { *h = append(*h, x.(replayEntry)) }
+
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,205 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+type testKeyStore map[KeyMode]string
+
+func (s testKeyStore) Key(email, org string, mode KeyMode) (string, error) {
+	if email != "info@megam.io" {
+		return "", ErrUnknownKey
+	}
+	key, ok := s[mode]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	return key, nil
+}
+
+var testKeys = testKeyStore{
+	ApiKeyMode:    "apikey",
+	MasterKeyMode: "masterkey",
+	PasswordMode:  "info@megam.io",
+}
+
+func (s *S) newVerifiedServer(c *check.C, v *Verifier) *httptest.Server {
+	return httptest.NewServer(v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		c.Check(ok, check.Equals, true)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(string(id.Mode) + " " + string(body)))
+	})))
+}
+
+func (s *S) TestVerifierModes(c *check.C) {
+	server := s.newVerifiedServer(c, NewVerifier(testKeys))
+	defer server.Close()
+	for _, args := range []ApiArgs{
+		{Email: "info@megam.io", Api_Key: "apikey"},
+		{Email: "info@megam.io", Master_Key: "masterkey"},
+		{Email: "info@megam.io", Password: "secret"},
+	} {
+		args.Url = server.URL + "/v2"
+		data, err := NewClient(args, "/assembly/update").Post(map[string]string{"id": "ASM1"})
+		c.Assert(err, check.IsNil)
+		c.Assert(strings.HasSuffix(string(data), ` {"id":"ASM1"}`), check.Equals, true)
+	}
+	args := ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: server.URL}
+	data, err := NewGateway(args).Client.Do(GET, "/balances?limit=2", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "api_key ")
+}
+
+func (s *S) TestVerifierRejectsBadSignatures(c *check.C) {
+	server := s.newVerifiedServer(c, NewVerifier(testKeys))
+	defer server.Close()
+	args := ApiArgs{Email: "info@megam.io", Api_Key: "wrong", Url: server.URL}
+	_, err := NewClient(args, "/accounts/info@megam.io").Get()
+	c.Assert(StatusCode(err), check.Equals, http.StatusUnauthorized)
+	c.Assert(err.(*APIError).Message(), check.Equals, ErrInvalidSignature.Error())
+	args = ApiArgs{Email: "other@megam.io", Api_Key: "apikey", Url: server.URL}
+	_, err = NewClient(args, "/accounts/other@megam.io").Get()
+	c.Assert(StatusCode(err), check.Equals, http.StatusUnauthorized)
+	response, err := http.Get(server.URL + "/accounts/info@megam.io")
+	c.Assert(err, check.IsNil)
+	response.Body.Close()
+	c.Assert(response.StatusCode, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *S) signedRequest(method, path, body string, date time.Time) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	auth := NewAuthly(ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: "http://example.com"})
+	headers, _ := auth.Sign(date.UTC().Format(time.RFC850), path, []byte(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func (s *S) nonceRequest(method, path, body string, date time.Time) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	auth := NewAuthly(ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: "http://example.com"})
+	headers, _ := auth.SignNonce(date.UTC().Format(time.RFC850), path, GetMD5Hash([]byte(body)))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func (s *S) TestLegacySignature(c *check.C) {
+	auth := NewAuthly(ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: "http://localhost:9000/v2"})
+	headers, err := auth.Sign("Tuesday, 03-May-16 10:00:00 UTC", "/assembly/update", []byte(`{"id":"ASM1"}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(headers[X_Megam_HMAC], check.Equals, "info@megam.io:f68062e22526383390cdc4e28535d3b2d6e008e3545b6c3fa4f0bb51ba08ad2b")
+	_, ok := headers[X_Megam_NONCE]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestVerifierClockSkew(c *check.C) {
+	v := NewVerifier(testKeys)
+	_, err := v.Verify(s.signedRequest(GET, "/accounts/info@megam.io", "", time.Now().Add(-time.Hour)))
+	c.Assert(err, check.Equals, ErrRequestExpired)
+	_, err = v.Verify(s.signedRequest(GET, "/accounts/info@megam.io", "", time.Now().Add(time.Hour)))
+	c.Assert(err, check.Equals, ErrRequestExpired)
+	id, err := v.Verify(s.signedRequest(GET, "/accounts/info@megam.io", "", time.Now().Add(-time.Minute)))
+	c.Assert(err, check.IsNil)
+	c.Assert(id, check.DeepEquals, &Identity{Email: "info@megam.io", Mode: ApiKeyMode})
+}
+
+func (s *S) TestVerifierRejectsReplays(c *check.C) {
+	v := NewVerifier(testKeys)
+	now := time.Now()
+	r := s.signedRequest(POST, "/assembly/update", `{"id":"ASM1"}`, now)
+	_, err := v.Verify(r)
+	c.Assert(err, check.IsNil)
+	replay := httptest.NewRequest(POST, "/assembly/update", strings.NewReader(`{"id":"ASM1"}`))
+	replay.Header = r.Header
+	_, err = v.Verify(replay)
+	c.Assert(err, check.Equals, ErrReplayedRequest)
+	_, err = v.Verify(s.signedRequest(POST, "/assembly/update", `{"id":"ASM1"}`, now))
+	c.Assert(err, check.Equals, ErrReplayedRequest)
+	_, err = v.Verify(s.nonceRequest(POST, "/assembly/update", `{"id":"ASM1"}`, now))
+	c.Assert(err, check.IsNil)
+	_, err = v.Verify(s.nonceRequest(POST, "/assembly/update", `{"id":"ASM1"}`, now))
+	c.Assert(err, check.IsNil)
+	_, err = v.Verify(s.signedRequest(POST, "/assembly/update", `{"id":"ASM2"}`, now))
+	c.Assert(err, check.IsNil)
+	_, err = v.Verify(s.signedRequest(GET, "/assembly/ASM1", "", now))
+	c.Assert(err, check.IsNil)
+	_, err = v.Verify(s.signedRequest(GET, "/assembly/ASM1", "", now))
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestVerifierNonce(c *check.C) {
+	v := NewVerifier(testKeys)
+	r := s.nonceRequest(POST, "/assembly/update", `{"id":"ASM1"}`, time.Now())
+	r.Header.Set(X_Megam_NONCE, "other")
+	_, err := v.Verify(r)
+	c.Assert(err, check.Equals, ErrInvalidSignature)
+	r = s.nonceRequest(POST, "/assembly/update", `{"id":"ASM1"}`, time.Now())
+	r.Header.Del(X_Megam_NONCE)
+	_, err = v.Verify(r)
+	c.Assert(err, check.Equals, ErrInvalidSignature)
+}
+
+func (s *S) TestVerifierMalformedRequests(c *check.C) {
+	v := NewVerifier(testKeys)
+	r := s.signedRequest(GET, "/accounts/info@megam.io", "", time.Now())
+	r.Header.Set(X_Megam_DATE, "yesterday")
+	_, err := v.Verify(r)
+	c.Assert(err, check.Equals, ErrMalformedRequest)
+	v.MaxBodySize = 4
+	_, err = v.Verify(s.signedRequest(POST, "/assembly/update", "1234", time.Now()))
+	c.Assert(err, check.IsNil)
+	_, err = v.Verify(s.signedRequest(POST, "/assembly/update", "12345", time.Now()))
+	c.Assert(err, check.Equals, ErrBodyTooLarge)
+}
+
+func (s *S) TestVerifierTrailingSlash(c *check.C) {
+	server := s.newVerifiedServer(c, NewVerifier(testKeys))
+	defer server.Close()
+	args := ApiArgs{Email: "info@megam.io", Api_Key: "apikey", Url: server.URL + "/v2"}
+	data, err := NewClient(args, "/assembly/update/").Post(map[string]string{"id": "ASM1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, `api_key {"id":"ASM1"}`)
+}
+
+func (s *S) TestMemoryReplayCacheExpires(c *check.C) {
+	now := time.Now()
+	cache := NewMemoryReplayCache()
+	cache.now = func() time.Time { return now }
+	c.Assert(cache.Seen("sig", now.Add(time.Minute)), check.Equals, false)
+	c.Assert(cache.Seen("sig", now.Add(time.Minute)), check.Equals, true)
+	c.Assert(cache.Seen("other", now.Add(3*time.Minute)), check.Equals, false)
+	now = now.Add(2 * time.Minute)
+	c.Assert(cache.Seen("sig", now.Add(time.Minute)), check.Equals, false)
+	c.Assert(cache.Seen("other", now.Add(time.Minute)), check.Equals, true)
+	c.Assert(cache.seen, check.HasLen, 2)
+	now = now.Add(2 * time.Minute)
+	c.Assert(cache.Seen("new", now.Add(time.Minute)), check.Equals, false)
+	c.Assert(cache.seen, check.HasLen, 1)
+	c.Assert(cache.expires, check.HasLen, 1)
+}
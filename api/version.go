@@ -0,0 +1,154 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionWarning tells that the gateway requires a newer version of the
+// client than Client.Version, as announced in its Supported-Gulp header.
+type VersionWarning struct {
+	// Version required by the gateway.
+	Supported string
+
+	// Version of the client.
+	Current string
+
+	// Whether the major versions differ.
+	Incompatible bool
+}
+
+func (w *VersionWarning) Error() string {
+	return fmt.Sprintf("Unsupported client version %s, the gateway requires at least %s.", w.Current, w.Supported)
+}
+
+// version is a semantic version. Missing minor and patch numbers are zero.
+type version struct {
+	parts []int
+	pre   []string
+}
+
+func parseVersion(s string) (version, error) {
+	var v version
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return version{}, fmt.Errorf("invalid version %q", s)
+		}
+		v.parts = append(v.parts, n)
+	}
+	for len(v.parts) < 3 {
+		v.parts = append(v.parts, 0)
+	}
+	return v, nil
+}
+
+func (v version) major() int {
+	return v.parts[0]
+}
+
+// compare returns -1, 0 or 1 when v is lower, equal or greater than o. A
+// pre-release is lower than its release, and pre-release identifiers are
+// compared numerically when both are numbers.
+func (v version) compare(o version) int {
+	for i := 0; i < len(v.parts) || i < len(o.parts); i++ {
+		a, b := 0, 0
+		if i < len(v.parts) {
+			a = v.parts[i]
+		}
+		if i < len(o.parts) {
+			b = o.parts[i]
+		}
+		if a != b {
+			return sign(a - b)
+		}
+	}
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, aerr := strconv.Atoi(v.pre[i])
+		b, berr := strconv.Atoi(o.pre[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if a != b {
+				return sign(a - b)
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case v.pre[i] != o.pre[i]:
+			if v.pre[i] < o.pre[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return sign(len(v.pre) - len(o.pre))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// checkVersion returns a warning when current does not satisfy the version
+// supported by the gateway. Unparseable versions are reported as
+// incompatible.
+func checkVersion(supported, current string) *VersionWarning {
+	if supported == "" {
+		return nil
+	}
+	w := &VersionWarning{Supported: supported, Current: current}
+	s, err := parseVersion(supported)
+	if err != nil {
+		w.Incompatible = true
+		return w
+	}
+	c, err := parseVersion(current)
+	if err != nil {
+		w.Incompatible = true
+		return w
+	}
+	if c.compare(s) >= 0 {
+		return nil
+	}
+	w.Incompatible = c.major() != s.major()
+	return w
+}
@@ -0,0 +1,88 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestVersionCompare(c *check.C) {
+	for _, t := range []struct {
+		a, b string
+		cmp  int
+	}{
+		{"2", "2.0.0", 0},
+		{"2.1", "2.0.9", 1},
+		{"1.9.9", "2", -1},
+		{"v2.0.0", "2", 0},
+		{"2.0.0-alpha", "2.0.0", -1},
+		{"2.0.0-alpha", "2.0.0-alpha.1", -1},
+		{"2.0.0-alpha.2", "2.0.0-alpha.10", -1},
+		{"2.0.0-alpha.1", "2.0.0-beta", -1},
+		{"2.0.0-1", "2.0.0-alpha", -1},
+		{"2.0.0+build.5", "2.0.0", 0},
+	} {
+		a, err := parseVersion(t.a)
+		c.Assert(err, check.IsNil)
+		b, err := parseVersion(t.b)
+		c.Assert(err, check.IsNil)
+		c.Check(a.compare(b), check.Equals, t.cmp, check.Commentf("%s <=> %s", t.a, t.b))
+		c.Check(b.compare(a), check.Equals, -t.cmp, check.Commentf("%s <=> %s", t.b, t.a))
+	}
+	_, err := parseVersion("two")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestCheckVersion(c *check.C) {
+	c.Assert(checkVersion("", "2"), check.IsNil)
+	c.Assert(checkVersion("2.0", "2"), check.IsNil)
+	c.Assert(checkVersion("1.5", "2"), check.IsNil)
+	c.Assert(checkVersion("2.1", "2"), check.DeepEquals, &VersionWarning{Supported: "2.1", Current: "2"})
+	c.Assert(checkVersion("3", "2"), check.DeepEquals, &VersionWarning{Supported: "3", Current: "2", Incompatible: true})
+	c.Assert(checkVersion("latest", "2").Incompatible, check.Equals, true)
+}
+
+func (s *S) TestClientVersionNegotiation(c *check.C) {
+	supported := "2.1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Supported-Gulp", supported)
+		w.Write([]byte(r.Header.Get("X-Megam-Client-Version")))
+	}))
+	defer server.Close()
+	var warnings []*VersionWarning
+	cl := s.newTestClient(server.URL, "/accounts")
+	cl.VersionHeader = "X-Megam-Client-Version"
+	cl.OnVersionWarning = func(w *VersionWarning) { warnings = append(warnings, w) }
+	data, err := cl.Get()
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "2")
+	c.Assert(warnings, check.DeepEquals, []*VersionWarning{{Supported: "2.1", Current: "2"}})
+	cl.StrictVersion = true
+	_, err = cl.Get()
+	c.Assert(err, check.IsNil)
+	supported = "3.0"
+	_, err = cl.Get()
+	c.Assert(err, check.DeepEquals, &VersionWarning{Supported: "3.0", Current: "2", Incompatible: true})
+	c.Assert(err, check.ErrorMatches, "Unsupported client version 2, the gateway requires at least 3.0.")
+	cl.Version = "3.0.1"
+	_, err = cl.Get()
+	c.Assert(err, check.IsNil)
+	c.Assert(warnings, check.HasLen, 2)
+}
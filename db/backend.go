@@ -0,0 +1,62 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"sync"
+)
+
+// Backend runs the operations of Fetchdb, FetchListdb, Storedb, Updatedb,
+// Deletedb, FetchPagedb and the batches. The one of the package is
+// ScyllaBackend, until SetBackend selects another, such as a MemoryBackend
+// in tests.
+type Backend interface {
+	Fetch(ctx context.Context, ops Options, data interface{}) error
+	FetchList(ctx context.Context, ops Options, limit int, dat, data interface{}) error
+	Store(ctx context.Context, ops Options, data interface{}) error
+	Update(ctx context.Context, ops Options, data map[string]interface{}) error
+	Delete(ctx context.Context, ops Options, data interface{}) error
+	FetchPage(ctx context.Context, ops Options, page Page, data interface{}) (string, error)
+	Apply(ctx context.Context, b *Batch) (bool, error)
+}
+
+// ScyllaBackend is the Backend of the Scylla clusters of the Options,
+// reached through DefaultPool.
+var ScyllaBackend Backend = scyllaBackend{}
+
+var (
+	current   = ScyllaBackend
+	currentMu sync.RWMutex
+)
+
+// SetBackend selects the Backend of the package, and returns the previous
+// one.
+func SetBackend(b Backend) Backend {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	previous := current
+	current = b
+	return previous
+}
+
+// CurrentBackend returns the Backend of the package.
+func CurrentBackend() Backend {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
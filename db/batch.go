@@ -0,0 +1,370 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/libgo/cmd"
+)
+
+// ErrMixedClusters is returned for a batch of mutations of several
+// clusters.
+var ErrMixedClusters = errors.New("Batch mutations must share a cluster.")
+
+type MutationKind int
+
+const (
+	InsertMutation MutationKind = iota
+	UpdateMutation
+	DeleteMutation
+	CounterMutation
+)
+
+// Mutation is a write of a row of the table of its Options, applied alone
+// with Apply or with others in a Batch. The row of updates, deletes and
+// counters is the one of the PksClauses and CcmsClauses.
+type Mutation struct {
+	Kind    MutationKind
+	Options Options
+
+	// The columns written, or the deltas of the counters.
+	Values map[string]interface{}
+
+	// Conditions of the write, a compare-and-set applied only when the
+	// row is missing, or when its columns have the values of If.
+	IfNotExists bool
+	If          map[string]interface{}
+
+	err error
+}
+
+// Insert writes data, a model, a pointer to one or a map of the columns.
+func Insert(ops Options, data interface{}) Mutation {
+	values, err := encodeRow(data)
+	return Mutation{Kind: InsertMutation, Options: ops, Values: values, err: err}
+}
+
+// Update writes the given columns.
+func Update(ops Options, values map[string]interface{}) Mutation {
+	return Mutation{Kind: UpdateMutation, Options: ops, Values: values}
+}
+
+// Delete removes the row.
+func Delete(ops Options) Mutation {
+	return Mutation{Kind: DeleteMutation, Options: ops}
+}
+
+// Increment adds the deltas to the counter columns, negative ones
+// decrementing them.
+func Increment(ops Options, deltas map[string]int64) Mutation {
+	values := make(map[string]interface{}, len(deltas))
+	for c, d := range deltas {
+		values[c] = d
+	}
+	return Mutation{Kind: CounterMutation, Options: ops, Values: values}
+}
+
+// WithIfNotExists returns the mutation applied only when the row is
+// missing, for inserts.
+func (m Mutation) WithIfNotExists() Mutation {
+	m.IfNotExists = true
+	return m
+}
+
+// WithIf returns the mutation applied only when the columns of the row
+// have the given values, for updates and deletes.
+func (m Mutation) WithIf(conditions map[string]interface{}) Mutation {
+	m.If = conditions
+	return m
+}
+
+// conditional tells whether the mutation is a lightweight transaction.
+func (m Mutation) conditional() bool {
+	return m.IfNotExists || len(m.If) > 0
+}
+
+func (m Mutation) check() error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.Options.TableName == "" {
+		return errors.New("Mutation without a table.")
+	}
+	switch {
+	case m.IfNotExists && m.Kind != InsertMutation:
+		return fmt.Errorf("IF NOT EXISTS only applies to inserts of %s.", m.Options.TableName)
+	case len(m.If) > 0 && m.Kind != UpdateMutation && m.Kind != DeleteMutation:
+		return fmt.Errorf("IF only applies to updates and deletes of %s.", m.Options.TableName)
+	case m.Kind != DeleteMutation && len(m.Values) == 0:
+		return fmt.Errorf("Mutation of %s without values.", m.Options.TableName)
+	case m.Kind != InsertMutation && len(m.Options.PksClauses) == 0:
+		return fmt.Errorf("Mutation of %s without a row key.", m.Options.TableName)
+	}
+	if m.Kind == CounterMutation {
+		for c, v := range m.Values {
+			if _, ok := counterDelta(v); !ok {
+				return fmt.Errorf("Counter %s of %s is not an integer.", c, m.Options.TableName)
+			}
+		}
+	}
+	return nil
+}
+
+// partition returns the table and the partition key of the row of the
+// mutation.
+func (m Mutation) partition() string {
+	columns := m.Options.PksClauses
+	if m.Kind == InsertMutation {
+		columns = m.Values
+	}
+	parts := []string{m.Options.Keyspace, m.Options.TableName}
+	for _, c := range m.Options.Pks {
+		parts = append(parts, keyOfValue(columns[c]))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+type BatchKind int
+
+const (
+	// Logged batches are applied entirely or not at all.
+	LoggedBatch BatchKind = iota
+	UnloggedBatch
+	// Counter batches hold counter mutations only.
+	CounterBatch
+)
+
+// Batch groups mutations of several tables, applied together. A batch
+// holding a conditional mutation is applied only when all its conditions
+// hold, and its mutations must then belong to the same partition.
+type Batch struct {
+	Kind      BatchKind
+	Mutations []Mutation
+}
+
+func NewBatch(kind BatchKind, mutations ...Mutation) *Batch {
+	return &Batch{Kind: kind, Mutations: mutations}
+}
+
+func (b *Batch) Add(mutations ...Mutation) *Batch {
+	b.Mutations = append(b.Mutations, mutations...)
+	return b
+}
+
+func (b *Batch) check() error {
+	if len(b.Mutations) == 0 {
+		return errors.New("Empty batch.")
+	}
+	conditional := false
+	for _, m := range b.Mutations {
+		conditional = conditional || m.conditional()
+	}
+	for _, m := range b.Mutations {
+		if err := m.check(); err != nil {
+			return err
+		}
+		if len(b.Mutations) == 1 {
+			continue
+		}
+		switch {
+		case m.Kind == CounterMutation && b.Kind != CounterBatch:
+			return fmt.Errorf("Counter mutation of %s out of a counter batch.", m.Options.TableName)
+		case m.Kind != CounterMutation && b.Kind == CounterBatch:
+			return fmt.Errorf("Mutation of %s in a counter batch.", m.Options.TableName)
+		case conditional && m.partition() != b.Mutations[0].partition():
+			return fmt.Errorf("Conditional batch spanning several partitions, including one of %s.", m.Options.TableName)
+		}
+	}
+	return nil
+}
+
+// Exec applies the mutations of the batch, and tells whether they were:
+// false when a condition does not hold.
+func (b *Batch) Exec(ctx context.Context) (bool, error) {
+	if err := b.check(); err != nil {
+		return false, err
+	}
+	return CurrentBackend().Apply(ctx, b)
+}
+
+// Apply applies a single mutation, and tells whether it was: false when a
+// condition does not hold.
+func Apply(ctx context.Context, m Mutation) (bool, error) {
+	kind := LoggedBatch
+	if m.Kind == CounterMutation {
+		kind = CounterBatch
+	}
+	return NewBatch(kind, m).Exec(ctx)
+}
+
+// statement returns the CQL of the mutation.
+func (m Mutation) statement() (string, []interface{}) {
+	ops := m.Options.withDefaults()
+	table := ops.TableName
+	if ops.Keyspace != "" {
+		table = ops.Keyspace + "." + table
+	}
+	var (
+		stmt   string
+		values []interface{}
+	)
+	columns := sortedKeys(m.Values)
+	switch m.Kind {
+	case InsertMutation:
+		marks := make([]string, len(columns))
+		for i, c := range columns {
+			marks[i] = "?"
+			values = append(values, m.Values[c])
+		}
+		stmt = "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(marks, ", ") + ")"
+		if m.IfNotExists {
+			stmt += " IF NOT EXISTS"
+		}
+		if ops.TTL > 0 {
+			stmt += fmt.Sprintf(" USING TTL %d", int(ops.TTL.Seconds()))
+		}
+		return stmt, values
+	case UpdateMutation, CounterMutation:
+		stmt = "UPDATE " + table
+		if ops.TTL > 0 && m.Kind == UpdateMutation {
+			stmt += fmt.Sprintf(" USING TTL %d", int(ops.TTL.Seconds()))
+		}
+		sets := make([]string, len(columns))
+		for i, c := range columns {
+			if m.Kind == CounterMutation {
+				sets[i] = c + " = " + c + " + ?"
+			} else {
+				sets[i] = c + " = ?"
+			}
+			values = append(values, m.Values[c])
+		}
+		stmt += " SET " + strings.Join(sets, ", ")
+	case DeleteMutation:
+		stmt = "DELETE FROM " + table
+	}
+	where, keys := equalities(m.Options.PksClauses, m.Options.CcmsClauses)
+	stmt += " WHERE " + where
+	values = append(values, keys...)
+	if len(m.If) > 0 {
+		cond, condValues := equalities(m.If)
+		stmt += " IF " + cond
+		values = append(values, condValues...)
+	}
+	return stmt, values
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// equalities returns "a = ? AND b = ?" and the values of the clauses.
+func equalities(clauses ...map[string]interface{}) (string, []interface{}) {
+	var (
+		terms  []string
+		values []interface{}
+	)
+	for _, clause := range clauses {
+		for _, c := range sortedKeys(clause) {
+			terms = append(terms, c+" = ?")
+			values = append(values, clause[c])
+		}
+	}
+	return strings.Join(terms, " AND "), values
+}
+
+func (scyllaBackend) Apply(ctx context.Context, b *Batch) (bool, error) {
+	ops := b.Mutations[0].Options.withDefaults()
+	for _, m := range b.Mutations[1:] {
+		k1, k2 := keyOf(ops), keyOf(m.Options)
+		k1.keyspace, k2.keyspace = "", ""
+		if k1 != k2 {
+			return false, ErrMixedClusters
+		}
+	}
+	t, err := newDBConn(ops)
+	if err != nil {
+		return false, err
+	}
+	session, err := t.Session()
+	if err != nil {
+		return false, err
+	}
+	conditional := false
+	for _, m := range b.Mutations {
+		conditional = conditional || m.conditional()
+	}
+	stmt, values := b.statement()
+	log.Debugf("%s (%s)", cmd.Colorfy("  > [scylla] apply", "blue", "", "bold"), stmt)
+	q := session.Query(stmt, values...)
+	if ops.Consistency != nil {
+		q = q.Consistency(*ops.Consistency)
+	}
+	if !conditional {
+		if err := await(ctx, ops.Timeout, q.Exec); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	var applied bool
+	err = await(ctx, ops.Timeout, func() error {
+		var err error
+		applied, err = q.MapScanCAS(make(map[string]interface{}))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+// statement returns the CQL of the batch, a single statement for a single
+// mutation. The batch is sent as one BEGIN BATCH statement rather than
+// with the batch API of gocql, so that a conditional batch is read with
+// MapScanCAS like a conditional statement.
+func (b *Batch) statement() (string, []interface{}) {
+	if len(b.Mutations) == 1 {
+		return b.Mutations[0].statement()
+	}
+	var (
+		stmts  []string
+		values []interface{}
+	)
+	for _, m := range b.Mutations {
+		stmt, v := m.statement()
+		stmts = append(stmts, stmt)
+		values = append(values, v...)
+	}
+	begin := "BEGIN BATCH "
+	switch b.Kind {
+	case UnloggedBatch:
+		begin = "BEGIN UNLOGGED BATCH "
+	case CounterBatch:
+		begin = "BEGIN COUNTER BATCH "
+	}
+	return begin + strings.Join(stmts, "; ") + "; APPLY BATCH", values
+}
@@ -0,0 +1,222 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMutationStatement(c *check.C) {
+	ops := readingOptions()
+	ops.TTL = time.Hour
+	stmt, values := Insert(ops, testReading{HostId: "h1", Seq: 1}).WithIfNotExists().statement()
+	c.Assert(stmt, check.Equals, "INSERT INTO memory_test.readings (host_id, seq, taken_at, value) VALUES (?, ?, ?, ?) IF NOT EXISTS USING TTL 3600")
+	c.Assert(values, check.HasLen, 4)
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	stmt, values = Update(ops, map[string]interface{}{"value": 0.8}).WithIf(map[string]interface{}{"value": 0.5}).statement()
+	c.Assert(stmt, check.Equals, "UPDATE memory_test.readings USING TTL 3600 SET value = ? WHERE host_id = ? AND seq = ? IF value = ?")
+	c.Assert(values, check.DeepEquals, []interface{}{0.8, "h1", 1, 0.5})
+	ops.TTL = 0
+	stmt, values = Increment(ops, map[string]int64{"hits": -2}).statement()
+	c.Assert(stmt, check.Equals, "UPDATE memory_test.readings SET hits = hits + ? WHERE host_id = ? AND seq = ?")
+	c.Assert(values, check.DeepEquals, []interface{}{int64(-2), "h1", 1})
+	stmt, _ = Delete(ops).statement()
+	c.Assert(stmt, check.Equals, "DELETE FROM memory_test.readings WHERE host_id = ? AND seq = ?")
+}
+
+func (s *S) TestBatchStatement(c *check.C) {
+	ops := readingOptions()
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	insert := Insert(readingOptions(), map[string]interface{}{"host_id": "h1", "seq": 2})
+	stmt, values := NewBatch(LoggedBatch, insert).statement()
+	c.Assert(stmt, check.Equals, "INSERT INTO memory_test.readings (host_id, seq) VALUES (?, ?)")
+	c.Assert(values, check.DeepEquals, []interface{}{"h1", 2})
+	stmt, values = NewBatch(UnloggedBatch, insert, Delete(ops)).statement()
+	c.Assert(stmt, check.Equals, "BEGIN UNLOGGED BATCH INSERT INTO memory_test.readings (host_id, seq) VALUES (?, ?); DELETE FROM memory_test.readings WHERE host_id = ? AND seq = ?; APPLY BATCH")
+	c.Assert(values, check.DeepEquals, []interface{}{"h1", 2, "h1", 1})
+	stmt, _ = NewBatch(LoggedBatch, insert, Delete(ops).WithIf(map[string]interface{}{"value": 0.5})).statement()
+	c.Assert(stmt, check.Matches, "BEGIN BATCH .* IF value = \\?; APPLY BATCH")
+	counter := Increment(ops, map[string]int64{"hits": 1})
+	stmt, _ = NewBatch(CounterBatch, counter, counter).statement()
+	c.Assert(stmt, check.Matches, "BEGIN COUNTER BATCH UPDATE .*; UPDATE .*; APPLY BATCH")
+}
+
+func (s *S) TestBatchCheck(c *check.C) {
+	ops := readingOptions()
+	ctx := context.Background()
+	_, err := NewBatch(LoggedBatch).Exec(ctx)
+	c.Assert(err, check.ErrorMatches, "Empty batch.")
+	_, err = Apply(ctx, Delete(ops))
+	c.Assert(err, check.ErrorMatches, "Mutation of readings without a row key.")
+	_, err = Apply(ctx, Insert(ops, "reading"))
+	c.Assert(err, check.NotNil)
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	_, err = Apply(ctx, Delete(ops).WithIfNotExists())
+	c.Assert(err, check.ErrorMatches, "IF NOT EXISTS only applies to inserts of readings.")
+	_, err = Apply(ctx, Update(ops, nil))
+	c.Assert(err, check.ErrorMatches, "Mutation of readings without values.")
+	counter := Increment(ops, map[string]int64{"hits": 1})
+	_, err = NewBatch(LoggedBatch, counter, counter).Exec(ctx)
+	c.Assert(err, check.ErrorMatches, "Counter mutation of readings out of a counter batch.")
+	_, err = NewBatch(CounterBatch, counter, Delete(ops)).Exec(ctx)
+	c.Assert(err, check.ErrorMatches, "Mutation of readings in a counter batch.")
+	counter.Values["hits"] = 1.5
+	_, err = Apply(ctx, counter)
+	c.Assert(err, check.ErrorMatches, "Counter hits of readings is not an integer.")
+	other := ops
+	other.PksClauses = map[string]interface{}{"host_id": "h2"}
+	set := Update(ops, map[string]interface{}{"value": 0.8}).WithIf(map[string]interface{}{"value": 0.5})
+	_, err = NewBatch(LoggedBatch, set, Delete(other)).Exec(ctx)
+	c.Assert(err, check.ErrorMatches, "Conditional batch spanning several partitions, including one of readings.")
+	_, err = NewBatch(LoggedBatch, set, Insert(readingOptions(), testReading{HostId: "h2", Seq: 1})).Exec(ctx)
+	c.Assert(err, check.ErrorMatches, "Conditional batch spanning several partitions, including one of readings.")
+}
+
+func (s *S) TestMemoryBackendIfNotExists(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	ctx := context.Background()
+	insert := Insert(readingOptions(), testReading{HostId: "h1", Seq: 1, Value: 0.5}).WithIfNotExists()
+	applied, err := Apply(ctx, insert)
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, true)
+	applied, err = Apply(ctx, Insert(readingOptions(), testReading{HostId: "h1", Seq: 1, Value: 0.9}).WithIfNotExists())
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, false)
+	rows := m.Rows("memory_test", "readings")
+	c.Assert(rows, check.HasLen, 1)
+	c.Assert(rows[0]["value"], check.Equals, 0.5)
+}
+
+func (s *S) TestMemoryBackendConditionsDoNotCreateTables(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	ops := readingOptions()
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	applied, err := Apply(context.Background(), Delete(ops).WithIf(map[string]interface{}{"value": 0.5}))
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, false)
+	c.Assert(m.tables, check.HasLen, 0)
+}
+
+func (s *S) TestMemoryBackendCompareAndSet(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	ctx := context.Background()
+	c.Assert(m.Store(ctx, readingOptions(), testReading{HostId: "h1", Seq: 1, Value: 0.5}), check.IsNil)
+	ops := readingOptions()
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	set := Update(ops, map[string]interface{}{"value": 0.8}).WithIf(map[string]interface{}{"value": 0.5})
+	applied, err := Apply(ctx, set)
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, true)
+	applied, err = Apply(ctx, set)
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, false)
+	c.Assert(m.Rows("memory_test", "readings")[0]["value"], check.Equals, 0.8)
+	ops.CcmsClauses = map[string]interface{}{"seq": 2}
+	applied, err = Apply(ctx, Delete(ops).WithIf(map[string]interface{}{"value": 0.8}))
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, false)
+}
+
+func (s *S) TestMemoryBackendConditionalBatch(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	ctx := context.Background()
+	c.Assert(m.Store(ctx, readingOptions(), testReading{HostId: "h1", Seq: 1, Value: 0.5}), check.IsNil)
+	ops := readingOptions()
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	b := NewBatch(LoggedBatch,
+		Insert(readingOptions(), testReading{HostId: "h1", Seq: 2, Value: 0.1}),
+		Update(ops, map[string]interface{}{"value": 0.9}).WithIf(map[string]interface{}{"value": 0.4}),
+	)
+	applied, err := b.Exec(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, false)
+	c.Assert(m.Rows("memory_test", "readings"), check.HasLen, 1)
+	b.Mutations[1] = b.Mutations[1].WithIf(map[string]interface{}{"value": 0.5})
+	applied, err = b.Exec(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, true)
+	rows := m.Rows("memory_test", "readings")
+	c.Assert(rows, check.HasLen, 2)
+	c.Assert(rows[0]["value"], check.Equals, 0.9)
+	c.Assert(rows[1]["value"], check.Equals, 0.1)
+}
+
+func (s *S) TestMemoryBackendApplyIsAllOrNothing(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	ctx := context.Background()
+	ops := readingOptions()
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	b := NewBatch(LoggedBatch,
+		Insert(readingOptions(), testReading{HostId: "h1", Seq: 1, Value: 0.5}),
+		Update(ops, map[string]interface{}{"value": 0.9}),
+	)
+	_, err := b.Exec(ctx)
+	c.Assert(err, check.ErrorMatches, "Missing key seq.")
+	c.Assert(m.Rows("memory_test", "readings"), check.HasLen, 0)
+	b = NewBatch(LoggedBatch,
+		Insert(readingOptions(), testReading{HostId: "h1", Seq: 1, Value: 0.5}),
+		Insert(readingOptions(), map[string]interface{}{"host_id": "h1", "value": 0.9}),
+	)
+	_, err = m.Apply(ctx, b)
+	c.Assert(err, check.ErrorMatches, "Missing key seq.")
+	c.Assert(m.Rows("memory_test", "readings"), check.HasLen, 0)
+}
+
+func (s *S) TestMemoryBackendCounters(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	ctx := context.Background()
+	ops := Options{TableName: "usage", Keyspace: "memory_test", Pks: []string{"account_id"}}
+	ops.PksClauses = map[string]interface{}{"account_id": "info@megam.io"}
+	applied, err := Apply(ctx, Increment(ops, map[string]int64{"launched": 2}))
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, true)
+	b := NewBatch(CounterBatch, Increment(ops, map[string]int64{"launched": -1, "deleted": 1}))
+	b.Add(Increment(ops, map[string]int64{"launched": 3}))
+	_, err = b.Exec(ctx)
+	c.Assert(err, check.IsNil)
+	c.Assert(m.Rows("memory_test", "usage"), check.DeepEquals, []map[string]interface{}{
+		{"account_id": "info@megam.io", "launched": int64(4), "deleted": int64(1)},
+	})
+	_, err = m.Apply(ctx, &Batch{Kind: CounterBatch, Mutations: []Mutation{
+		{Kind: CounterMutation, Options: ops, Values: map[string]interface{}{"launched": 2}},
+	}})
+	c.Assert(err, check.IsNil)
+	c.Assert(m.Rows("memory_test", "usage")[0]["launched"], check.Equals, int64(6))
+	_, err = m.Apply(ctx, &Batch{Kind: CounterBatch, Mutations: []Mutation{
+		{Kind: CounterMutation, Options: ops, Values: map[string]interface{}{"deleted": 1, "launched": "1"}},
+	}})
+	c.Assert(err, check.ErrorMatches, "Counter launched of usage is not an integer.")
+	c.Assert(m.Rows("memory_test", "usage")[0]["deleted"], check.Equals, int64(1))
+	c.Assert(m.Store(ctx, ops, map[string]interface{}{"account_id": "info@megam.io", "launched": 3}), check.IsNil)
+	_, err = Apply(ctx, Increment(ops, map[string]int64{"launched": 1}))
+	c.Assert(err, check.IsNil)
+	c.Assert(m.Rows("memory_test", "usage")[0]["launched"], check.Equals, int64(4))
+}
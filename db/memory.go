@@ -0,0 +1,641 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megamsys/gocassa"
+)
+
+// MemoryBackend is a Backend keeping the rows in memory, so that the code
+// writing to Scylla can be tested without a cluster:
+//
+//	m := db.NewMemoryBackend()
+//	defer db.SetBackend(db.SetBackend(m))
+//
+// A row is identified by its Pks and Ccms columns, rows are listed by
+// partition then in the clustering order, and they expire after the TTL of
+// the options. Clauses only match equal values, as with ALLOW FILTERING.
+type MemoryBackend struct {
+	mu     sync.Mutex
+	tables map[string]*memoryTable
+	now    func() time.Time
+}
+
+type memoryTable struct {
+	pks  []string
+	ccms []string
+	desc map[string]bool
+	rows map[string]*memoryRow
+}
+
+type memoryRow struct {
+	columns map[string]interface{}
+	expires time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{tables: make(map[string]*memoryTable), now: time.Now}
+}
+
+func newMemoryTable(ops Options) *memoryTable {
+	t := &memoryTable{pks: ops.Pks, ccms: ops.Ccms, desc: make(map[string]bool), rows: make(map[string]*memoryRow)}
+	for _, c := range ops.Descending {
+		t.desc[c] = true
+	}
+	return t
+}
+
+// table returns the table of ops, created with the keys of ops if create.
+func (m *MemoryBackend) table(ops Options, create bool) *memoryTable {
+	name := ops.Keyspace + "." + ops.TableName
+	t, ok := m.tables[name]
+	if !ok && create {
+		t = newMemoryTable(ops)
+		m.tables[name] = t
+	}
+	return t
+}
+
+func (t *memoryTable) key(columns map[string]interface{}) (string, error) {
+	parts := make([]string, 0, len(t.pks)+len(t.ccms))
+	for _, c := range append(append([]string(nil), t.pks...), t.ccms...) {
+		v, ok := columns[c]
+		if !ok {
+			return "", fmt.Errorf("Missing key %s.", c)
+		}
+		parts = append(parts, keyOfValue(v))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+// live returns the rows not expired matching all the clauses, in order.
+func (t *memoryTable) live(now time.Time, clauses ...map[string]interface{}) []*memoryRow {
+	var rows []*memoryRow
+	for _, r := range t.rows {
+		if !r.expires.IsZero() && !now.Before(r.expires) {
+			continue
+		}
+		if r.matches(clauses...) {
+			rows = append(rows, r)
+		}
+	}
+	sort.Sort(memoryRows{rows, append(append([]string(nil), t.pks...), t.ccms...), t.desc})
+	return rows
+}
+
+// memoryRows sorts rows by the values of the columns of order, ascending
+// unless desc.
+type memoryRows struct {
+	rows  []*memoryRow
+	order []string
+	desc  map[string]bool
+}
+
+func (r memoryRows) Len() int      { return len(r.rows) }
+func (r memoryRows) Swap(i, j int) { r.rows[i], r.rows[j] = r.rows[j], r.rows[i] }
+
+func (r memoryRows) Less(i, j int) bool {
+	for _, c := range r.order {
+		if cmp := compareValues(r.rows[i].columns[c], r.rows[j].columns[c]); cmp != 0 {
+			return (cmp < 0) != r.desc[c]
+		}
+	}
+	return false
+}
+
+func (r *memoryRow) matches(clauses ...map[string]interface{}) bool {
+	for _, clause := range clauses {
+		for c, v := range clause {
+			if compareValues(r.columns[c], v) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *MemoryBackend) expiry(ops Options) time.Time {
+	if ttl := ops.withDefaults().TTL; ttl > 0 {
+		return m.now().Add(ttl)
+	}
+	return time.Time{}
+}
+
+func (m *MemoryBackend) Fetch(ctx context.Context, ops Options, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(ops, false)
+	if t == nil {
+		return gocassa.RowNotFoundError{}
+	}
+	rows := t.live(m.now(), ops.PksClauses, ops.CcmsClauses)
+	if len(rows) == 0 {
+		return gocassa.RowNotFoundError{}
+	}
+	return decodeRow(rows[0].columns, data)
+}
+
+func (m *MemoryBackend) FetchList(ctx context.Context, ops Options, limit int, dat, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rows []*memoryRow
+	if t := m.table(ops, false); t != nil {
+		rows = t.live(m.now(), ops.PksClauses)
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return decodeRows(columnsOfRows(rows), data)
+}
+
+func columnsOfRows(rows []*memoryRow) []map[string]interface{} {
+	columns := make([]map[string]interface{}, len(rows))
+	for i, r := range rows {
+		columns[i] = r.columns
+	}
+	return columns
+}
+
+// token returns the token of the partition of a row, a hash of its
+// partition key as the Murmur3 tokens of Scylla.
+func (t *memoryTable) token(r *memoryRow) int64 {
+	h := fnv.New64a()
+	for _, c := range t.pks {
+		h.Write([]byte(keyOfValue(r.columns[c])))
+		h.Write([]byte{0})
+	}
+	token := int64(h.Sum64())
+	if token == math.MinInt64 {
+		token = math.MaxInt64
+	}
+	return token
+}
+
+// FetchPage pages the rows of FetchList, the cursors being offsets in the
+// rows.
+func (m *MemoryBackend) FetchPage(ctx context.Context, ops Options, page Page, data interface{}) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := page.check(ops); err != nil {
+		return "", err
+	}
+	state, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return "", err
+	}
+	offset := 0
+	if state != nil {
+		if offset, err = strconv.Atoi(string(state)); err != nil || offset < 0 {
+			return "", ErrInvalidCursor
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rows []*memoryRow
+	if t := m.table(ops, false); t != nil {
+		for _, r := range t.live(m.now(), ops.PksClauses) {
+			if page.Range == nil || page.Range.contains(t.token(r)) {
+				rows = append(rows, r)
+			}
+		}
+	}
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	end, next := offset+page.size(), ""
+	if end < len(rows) {
+		next = encodeCursor([]byte(strconv.Itoa(end)))
+	} else {
+		end = len(rows)
+	}
+	return next, decodeRows(columnsOfRows(rows[offset:end]), data)
+}
+
+func (m *MemoryBackend) Store(ctx context.Context, ops Options, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	columns, err := encodeRow(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store(ops, columns)
+}
+
+func (m *MemoryBackend) store(ops Options, columns map[string]interface{}) error {
+	t := m.table(ops, true)
+	key, err := t.key(columns)
+	if err != nil {
+		return err
+	}
+	t.rows[key] = &memoryRow{columns: columns, expires: m.expiry(ops)}
+	return nil
+}
+
+func (m *MemoryBackend) Update(ctx context.Context, ops Options, data map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.update(ops, data, false)
+}
+
+// update writes the columns of the row of the clauses of ops, adding them
+// to the current values when counter.
+func (m *MemoryBackend) update(ops Options, data map[string]interface{}, counter bool) error {
+	t := m.table(ops, true)
+	columns := make(map[string]interface{})
+	for _, clause := range []map[string]interface{}{ops.PksClauses, ops.CcmsClauses} {
+		for c, v := range clause {
+			columns[c] = v
+		}
+	}
+	key, err := t.key(columns)
+	if err != nil {
+		return err
+	}
+	r, ok := t.rows[key]
+	if !ok || (!r.expires.IsZero() && !m.now().Before(r.expires)) {
+		r = &memoryRow{columns: columns}
+		t.rows[key] = r
+	}
+	for c, v := range data {
+		if counter {
+			delta, ok := counterDelta(v)
+			if !ok {
+				return fmt.Errorf("Counter %s of %s is not an integer.", c, ops.TableName)
+			}
+			current, _ := counterDelta(r.columns[c])
+			v = current + delta
+		}
+		r.columns[c] = v
+	}
+	if expires := m.expiry(ops); !expires.IsZero() && !counter {
+		r.expires = expires
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, ops Options, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delete(ops)
+	return nil
+}
+
+func (m *MemoryBackend) delete(ops Options) {
+	t := m.table(ops, false)
+	if t == nil {
+		return
+	}
+	for key, r := range t.rows {
+		if r.matches(ops.PksClauses, ops.CcmsClauses) {
+			delete(t.rows, key)
+		}
+	}
+}
+
+// Apply checks the conditions and the rows of all the mutations of the
+// batch before applying any.
+func (m *MemoryBackend) Apply(ctx context.Context, b *Batch) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rows := make([]map[string]interface{}, len(b.Mutations))
+	for i, mt := range b.Mutations {
+		var err error
+		if rows[i], err = m.prepare(mt); err != nil {
+			return false, err
+		}
+	}
+	for _, mt := range b.Mutations {
+		ok, err := m.holds(mt)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	for i, mt := range b.Mutations {
+		var err error
+		switch mt.Kind {
+		case InsertMutation:
+			err = m.store(mt.Options, rows[i])
+		case UpdateMutation, CounterMutation:
+			err = m.update(mt.Options, mt.Values, mt.Kind == CounterMutation)
+		case DeleteMutation:
+			m.delete(mt.Options)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// prepare checks that the mutation can be applied, returning the columns
+// of the row of an insert.
+func (m *MemoryBackend) prepare(mt Mutation) (map[string]interface{}, error) {
+	t := m.table(mt.Options, false)
+	if t == nil {
+		t = newMemoryTable(mt.Options)
+	}
+	switch mt.Kind {
+	case InsertMutation:
+		columns, err := encodeRow(mt.Values)
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.key(columns)
+		return columns, err
+	case UpdateMutation, CounterMutation:
+		columns := make(map[string]interface{})
+		for _, clause := range []map[string]interface{}{mt.Options.PksClauses, mt.Options.CcmsClauses} {
+			for c, v := range clause {
+				columns[c] = v
+			}
+		}
+		if _, err := t.key(columns); err != nil {
+			return nil, err
+		}
+		if mt.Kind == CounterMutation {
+			for c, v := range mt.Values {
+				if _, ok := counterDelta(v); !ok {
+					return nil, fmt.Errorf("Counter %s of %s is not an integer.", c, mt.Options.TableName)
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// holds tells whether the conditions of a mutation hold.
+func (m *MemoryBackend) holds(mt Mutation) (bool, error) {
+	if !mt.conditional() {
+		return true, nil
+	}
+	t := m.table(mt.Options, false)
+	if t == nil {
+		return mt.IfNotExists, nil
+	}
+	var rows []*memoryRow
+	if mt.IfNotExists {
+		key, err := t.key(mt.Values)
+		if err != nil {
+			return false, err
+		}
+		if r, ok := t.rows[key]; ok {
+			rows = t.live(m.now(), r.columns)
+		}
+		return len(rows) == 0, nil
+	}
+	rows = t.live(m.now(), mt.Options.PksClauses, mt.Options.CcmsClauses)
+	return len(rows) == 1 && rows[0].matches(mt.If), nil
+}
+
+// Rows returns the live rows of a table, as their columns, in order.
+func (m *MemoryBackend) Rows(keyspace, table string) []map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(Options{Keyspace: keyspace, TableName: table}, false)
+	if t == nil {
+		return nil
+	}
+	var rows []map[string]interface{}
+	for _, r := range t.live(m.now()) {
+		columns := make(map[string]interface{}, len(r.columns))
+		for c, v := range r.columns {
+			columns[c] = v
+		}
+		rows = append(rows, columns)
+	}
+	return rows
+}
+
+// Reset drops all the tables.
+func (m *MemoryBackend) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tables = make(map[string]*memoryTable)
+}
+
+// encodeRow returns the columns of data, a model, a pointer to one or a
+// map of the columns.
+func encodeRow(data interface{}) (map[string]interface{}, error) {
+	if m, ok := data.(map[string]interface{}); ok {
+		columns := make(map[string]interface{}, len(m))
+		for c, v := range m {
+			columns[c] = v
+		}
+		return columns, nil
+	}
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Can't store a %T.", data)
+	}
+	columns := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		if c, ok := columnOf(v.Type().Field(i)); ok {
+			columns[c] = v.Field(i).Interface()
+		}
+	}
+	return columns, nil
+}
+
+// decodeRows sets data, a pointer to a slice of models or of pointers to
+// them, to the rows.
+func decodeRows(rows []map[string]interface{}, data interface{}) error {
+	slice := reflect.ValueOf(data)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Expected a pointer to a slice, got %T.", data)
+	}
+	typ := slice.Elem().Type()
+	out := reflect.MakeSlice(typ, len(rows), len(rows))
+	for i, r := range rows {
+		elem := out.Index(i)
+		if typ.Elem().Kind() == reflect.Ptr {
+			elem.Set(reflect.New(typ.Elem().Elem()))
+		}
+		if err := decodeRow(r, elem.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	slice.Elem().Set(out)
+	return nil
+}
+
+// decodeRow sets the fields of data, a pointer to a model, to the columns.
+func decodeRow(columns map[string]interface{}, data interface{}) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Expected a pointer, got %T.", data)
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("Can't read into a %T.", data)
+	}
+	for i := 0; i < v.NumField(); i++ {
+		c, ok := columnOf(v.Type().Field(i))
+		if !ok {
+			continue
+		}
+		value := reflect.ValueOf(columns[c])
+		field := v.Field(i)
+		switch {
+		case !value.IsValid():
+			field.Set(reflect.Zero(field.Type()))
+		case value.Type().AssignableTo(field.Type()):
+			field.Set(value)
+		case value.Type().ConvertibleTo(field.Type()):
+			field.Set(value.Convert(field.Type()))
+		default:
+			return fmt.Errorf("Can't read %s, a %s, into a %s.", c, value.Type(), field.Type())
+		}
+	}
+	return nil
+}
+
+func keyOfValue(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return fmt.Sprint(v)
+}
+
+// compareValues orders the values of a column: numbers, strings, booleans
+// and times by value, the others by their text.
+func compareValues(a, b interface{}) int {
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.IsValid() && vb.IsValid() {
+		switch ka, kb := kindOf(va), kindOf(vb); {
+		case ka == reflect.Int && kb == reflect.Int:
+			x, y := va.Int(), vb.Int()
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		case isNumber(ka) && isNumber(kb), ka == reflect.Bool && kb == reflect.Bool:
+			return compareFloats(numberOf(va), numberOf(vb))
+		case ka == reflect.String && kb == reflect.String:
+			return strings.Compare(va.String(), vb.String())
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// kindOf groups the kinds of numbers as Int and Float64.
+func kindOf(v reflect.Value) reflect.Kind {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.Float64
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return v.Kind()
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k == reflect.Int || k == reflect.Float64
+}
+
+func numberOf(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// counterDelta returns v, a counter or a delta of one, as an int64.
+func counterDelta(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() <= math.MaxInt64 {
+			return int64(rv.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/megamsys/gocassa"
+	"gopkg.in/check.v1"
+)
+
+type testReading struct {
+	HostId  string    `cql:"host_id"`
+	Seq     int       `cql:"seq"`
+	Value   float64   `cql:"value"`
+	TakenAt time.Time `cql:"taken_at"`
+}
+
+func readingOptions() Options {
+	return Options{
+		TableName: "readings",
+		Keyspace:  "memory_test",
+		Pks:       []string{"host_id"},
+		Ccms:      []string{"seq"},
+	}
+}
+
+func (s *S) TestMemoryBackendFetch(c *check.C) {
+	m := NewMemoryBackend()
+	ctx := context.Background()
+	ops := readingOptions()
+	c.Assert(m.Store(ctx, ops, testReading{HostId: "h1", Seq: 1, Value: 0.5}), check.IsNil)
+	c.Assert(m.Store(ctx, ops, &testReading{HostId: "h1", Seq: 2, Value: 0.7}), check.IsNil)
+	c.Assert(m.Store(ctx, ops, testReading{HostId: "h1", Seq: 1, Value: 0.9}), check.IsNil)
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	var r testReading
+	c.Assert(m.Fetch(ctx, ops, &r), check.IsNil)
+	c.Assert(r, check.DeepEquals, testReading{HostId: "h1", Seq: 1, Value: 0.9})
+	ops.CcmsClauses = map[string]interface{}{"seq": 3}
+	err := m.Fetch(ctx, ops, &r)
+	c.Assert(err, check.FitsTypeOf, gocassa.RowNotFoundError{})
+	err = m.Fetch(ctx, Options{TableName: "missing"}, &r)
+	c.Assert(err, check.FitsTypeOf, gocassa.RowNotFoundError{})
+	c.Assert(m.Store(ctx, readingOptions(), testReading{}), check.IsNil)
+	c.Assert(m.Store(ctx, readingOptions(), map[string]interface{}{"seq": 4}), check.ErrorMatches, "Missing key host_id.")
+}
+
+func (s *S) TestMemoryBackendFetchListOrder(c *check.C) {
+	m := NewMemoryBackend()
+	ctx := context.Background()
+	ops := readingOptions()
+	for _, r := range []testReading{{"h2", 1, 0, time.Time{}}, {"h1", 10, 0, time.Time{}}, {"h1", 2, 0, time.Time{}}, {"h1", 3, 0, time.Time{}}} {
+		c.Assert(m.Store(ctx, ops, r), check.IsNil)
+	}
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	var readings []testReading
+	c.Assert(m.FetchList(ctx, ops, 0, testReading{}, &readings), check.IsNil)
+	c.Assert(readings, check.HasLen, 3)
+	c.Assert([]int{readings[0].Seq, readings[1].Seq, readings[2].Seq}, check.DeepEquals, []int{2, 3, 10})
+	c.Assert(m.FetchList(ctx, ops, 2, testReading{}, &readings), check.IsNil)
+	c.Assert(readings, check.HasLen, 2)
+	var pointers []*testReading
+	ops.PksClauses = map[string]interface{}{}
+	c.Assert(m.FetchList(ctx, ops, 0, testReading{}, &pointers), check.IsNil)
+	c.Assert(pointers, check.HasLen, 4)
+	c.Assert(pointers[3].HostId, check.Equals, "h2")
+	ops.TableName = "missing"
+	c.Assert(m.FetchList(ctx, ops, 0, testReading{}, &readings), check.IsNil)
+	c.Assert(readings, check.HasLen, 0)
+}
+
+func (s *S) TestMemoryBackendDescendingOrder(c *check.C) {
+	m := NewMemoryBackend()
+	ctx := context.Background()
+	ops := readingOptions()
+	ops.Descending = []string{"seq"}
+	for _, r := range []testReading{{"h1", 2, 0, time.Time{}}, {"h1", 10, 0, time.Time{}}, {"h1", 3, 0, time.Time{}}} {
+		c.Assert(m.Store(ctx, ops, r), check.IsNil)
+	}
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	var readings []testReading
+	next, err := m.FetchPage(ctx, ops, Page{Size: 2}, &readings)
+	c.Assert(err, check.IsNil)
+	c.Assert([]int{readings[0].Seq, readings[1].Seq}, check.DeepEquals, []int{10, 3})
+	_, err = m.FetchPage(ctx, ops, Page{Size: 2, Cursor: next}, &readings)
+	c.Assert(err, check.IsNil)
+	c.Assert(readings, check.HasLen, 1)
+	c.Assert(readings[0].Seq, check.Equals, 2)
+}
+
+func (s *S) TestMemoryBackendTTL(c *check.C) {
+	m := NewMemoryBackend()
+	now := time.Date(2016, 5, 3, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+	ops := readingOptions()
+	ops.TTL = time.Minute
+	c.Assert(m.Store(ctx, ops, testReading{HostId: "h1", Seq: 1}), check.IsNil)
+	c.Assert(m.Rows("memory_test", "readings"), check.HasLen, 1)
+	now = now.Add(time.Minute)
+	c.Assert(m.Rows("memory_test", "readings"), check.HasLen, 0)
+}
+
+func (s *S) TestMemoryBackendUpdateAndDelete(c *check.C) {
+	m := NewMemoryBackend()
+	ctx := context.Background()
+	ops := readingOptions()
+	taken := time.Date(2016, 5, 3, 10, 0, 0, 0, time.UTC)
+	c.Assert(m.Store(ctx, ops, testReading{HostId: "h1", Seq: 1, Value: 0.5}), check.IsNil)
+	c.Assert(m.Store(ctx, ops, testReading{HostId: "h1", Seq: 2, Value: 0.5}), check.IsNil)
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	ops.CcmsClauses = map[string]interface{}{"seq": 1}
+	c.Assert(m.Update(ctx, ops, map[string]interface{}{"value": 0.8, "taken_at": taken}), check.IsNil)
+	ops.PksClauses = map[string]interface{}{"host_id": "h2"}
+	c.Assert(m.Update(ctx, ops, map[string]interface{}{"value": 0.1}), check.IsNil)
+	c.Assert(m.Rows("memory_test", "readings"), check.DeepEquals, []map[string]interface{}{
+		{"host_id": "h1", "seq": 1, "value": 0.8, "taken_at": taken},
+		{"host_id": "h1", "seq": 2, "value": 0.5, "taken_at": time.Time{}},
+		{"host_id": "h2", "seq": 1, "value": 0.1},
+	})
+	ops.CcmsClauses = map[string]interface{}{}
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	c.Assert(m.Delete(ctx, ops, nil), check.IsNil)
+	c.Assert(m.Rows("memory_test", "readings"), check.HasLen, 1)
+	ops.PksClauses = map[string]interface{}{}
+	c.Assert(m.Update(ctx, ops, map[string]interface{}{"value": 0.1}), check.ErrorMatches, "Missing key host_id.")
+	m.Reset()
+	c.Assert(m.Rows("memory_test", "readings"), check.IsNil)
+}
+
+func (s *S) TestMemoryBackendContext(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := NewMemoryBackend().Store(ctx, readingOptions(), testReading{HostId: "h1"})
+	c.Assert(err, check.Equals, context.Canceled)
+}
+
+func (s *S) TestSetBackend(c *check.C) {
+	m := NewMemoryBackend()
+	previous := SetBackend(m)
+	defer SetBackend(previous)
+	c.Assert(previous, check.Equals, ScyllaBackend)
+	c.Assert(CurrentBackend(), check.Equals, Backend(m))
+	r, err := NewRepository(testEvent{}, Options{Keyspace: "memory_test"})
+	c.Assert(err, check.IsNil)
+	ctx := context.Background()
+	for _, host := range []string{"h2", "h1"} {
+		e := testEvent{EventType: "compute.instance.launched", HostId: host, AccountId: "info@megam.io", Data: []string{"up"}}
+		c.Assert(r.Put(ctx, e), check.IsNil)
+	}
+	c.Assert(m.Rows("memory_test", "test_event"), check.HasLen, 2)
+	e := testEvent{EventType: "compute.instance.launched", HostId: "h1", AccountId: "info@megam.io"}
+	c.Assert(r.Get(ctx, &e), check.IsNil)
+	c.Assert(e.Data, check.DeepEquals, []string{"up"})
+	var events []testEvent
+	where := map[string]interface{}{"account_id": "info@megam.io"}
+	c.Assert(r.List(ctx, where, ListOptions{Limit: 1, Offset: 1}, &events), check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].HostId, check.Equals, "h2")
+	c.Assert(r.Delete(ctx, e), check.IsNil)
+	c.Assert(m.Rows("memory_test", "test_event"), check.HasLen, 1)
+}
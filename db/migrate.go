@@ -0,0 +1,448 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/gocql"
+	"github.com/megamsys/libgo/cmd"
+)
+
+const (
+	// MigrationsTable keeps the versions applied to a keyspace.
+	MigrationsTable = "schema_migrations"
+
+	// MigrationsLockTable keeps the lease of the host applying migrations
+	// to a keyspace.
+	MigrationsLockTable = "schema_migrations_lock"
+
+	// DefaultMigrationLease is the lease of the migrators without one.
+	DefaultMigrationLease = 10 * time.Minute
+)
+
+// ErrMigrationsLocked is returned when another host holds the lease of the
+// migrations of the keyspace.
+var ErrMigrationsLocked = errors.New("Migrations are being applied by another host.")
+
+// Migration is a versioned change of the schema of a keyspace. Up and Down
+// hold CQL statements separated by semicolons.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration is applied to the keyspace.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.cql$`)
+
+// LoadMigrations reads the migrations of a directory, named as
+// 0001_create_events_for_obc.up.cql and 0001_create_events_for_obc.down.cql.
+// The down file is optional.
+func LoadMigrations(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		parts := migrationFile.FindStringSubmatch(f.Name())
+		if f.IsDir() || parts == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(parts[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		} else if m.Name != parts[2] {
+			return nil, fmt.Errorf("Migration %d is both %s and %s.", version, m.Name, parts[2])
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if parts[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("Migration %d (%s) has no up statements.", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Sort(byMigrationVersion(migrations))
+	return migrations, nil
+}
+
+type byMigrationVersion []Migration
+
+func (m byMigrationVersion) Len() int           { return len(m) }
+func (m byMigrationVersion) Less(i, j int) bool { return m[i].Version < m[j].Version }
+func (m byMigrationVersion) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// statements splits CQL on the semicolons outside of quotes and comments,
+// "--", "//" and "/* */" ones.
+func statements(cql string) []string {
+	var (
+		stmts []string
+		b     bytes.Buffer
+		quote rune
+	)
+	runes := []rune(cql)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-',
+			r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			continue
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i < len(runes) && !(runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/') {
+				i++
+			}
+			// skips the "*", the loop skips the "/"
+			i++
+			b.WriteRune(' ')
+			continue
+		case r == ';':
+			if s := strings.TrimSpace(b.String()); s != "" {
+				stmts = append(stmts, s)
+			}
+			b.Reset()
+			continue
+		}
+		b.WriteRune(r)
+	}
+	if s := strings.TrimSpace(b.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+// Replication is the replication of a keyspace created by a Migrator,
+// SimpleStrategy with the given Factor when DataCenters is empty.
+type Replication struct {
+	Factor      int
+	DataCenters map[string]int
+}
+
+func (r Replication) cql() string {
+	if len(r.DataCenters) == 0 {
+		factor := r.Factor
+		if factor == 0 {
+			factor = 1
+		}
+		return fmt.Sprintf("{'class': 'SimpleStrategy', 'replication_factor': %d}", factor)
+	}
+	dcs := make([]string, 0, len(r.DataCenters))
+	for dc := range r.DataCenters {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	cql := "{'class': 'NetworkTopologyStrategy'"
+	for _, dc := range dcs {
+		cql += fmt.Sprintf(", '%s': %d", dc, r.DataCenters[dc])
+	}
+	return cql + "}"
+}
+
+// Executor runs CQL statements on a keyspace, or on the cluster when its
+// keyspace is empty.
+type Executor interface {
+	Exec(stmt string, values ...interface{}) error
+	Query(stmt string, values ...interface{}) ([]map[string]interface{}, error)
+
+	// ExecCAS runs a conditional statement, and tells whether it was
+	// applied.
+	ExecCAS(stmt string, values ...interface{}) (bool, error)
+	Close()
+}
+
+type sessionExecutor struct {
+	*gocql.Session
+}
+
+func (s sessionExecutor) Exec(stmt string, values ...interface{}) error {
+	return s.Session.Query(stmt, values...).Exec()
+}
+
+func (s sessionExecutor) Query(stmt string, values ...interface{}) ([]map[string]interface{}, error) {
+	return s.Session.Query(stmt, values...).Iter().SliceMap()
+}
+
+func (s sessionExecutor) ExecCAS(stmt string, values ...interface{}) (bool, error) {
+	return s.Session.Query(stmt, values...).MapScanCAS(make(map[string]interface{}))
+}
+
+func connectExecutor(ops Options, keyspace string) (Executor, error) {
+	ops = ops.withDefaults()
+	cluster := gocql.NewCluster(ops.Hosts...)
+	cluster.Keyspace = keyspace
+	if ops.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: ops.Username, Password: ops.Password}
+	}
+	if ops.Consistency != nil {
+		cluster.Consistency = *ops.Consistency
+	}
+	if ops.Timeout > 0 {
+		cluster.Timeout = ops.Timeout
+	}
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	return sessionExecutor{session}, nil
+}
+
+// Migrator applies migrations to the keyspace of its Options, creating the
+// keyspace with its Replication when missing. Statements are not
+// transactional in CQL: a migration that fails midway is not recorded, and
+// its statements should be written to be run again (IF NOT EXISTS).
+//
+// A migrator takes a lease on the keyspace before applying or reverting
+// migrations, so hosts deployed together do not apply them twice.
+type Migrator struct {
+	Options     Options
+	Replication Replication
+	Migrations  []Migration
+
+	// How long the lease is held at most, for a host that dies while
+	// applying migrations. Zero means DefaultMigrationLease.
+	Lease time.Duration
+
+	connect func(ops Options, keyspace string) (Executor, error)
+}
+
+func NewMigrator(ops Options, migrations []Migration) *Migrator {
+	return &Migrator{Options: ops, Migrations: migrations, connect: connectExecutor}
+}
+
+// open creates the keyspace and the migrations table when missing, and
+// returns an executor on the keyspace.
+func (m *Migrator) open() (Executor, error) {
+	if m.Options.Keyspace == "" {
+		return nil, errors.New("Migrations need a keyspace.")
+	}
+	cluster, err := m.connect(m.Options, "")
+	if err != nil {
+		return nil, err
+	}
+	err = cluster.Exec(fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", m.Options.Keyspace, m.Replication.cql()))
+	cluster.Close()
+	if err != nil {
+		return nil, err
+	}
+	ks, err := m.connect(m.Options, m.Options.Keyspace)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS " + MigrationsTable + " (version int PRIMARY KEY, name text, applied_at timestamp)",
+		"CREATE TABLE IF NOT EXISTS " + MigrationsLockTable + " (name text PRIMARY KEY, owner text, locked_at timestamp)",
+	} {
+		if err = ks.Exec(stmt); err != nil {
+			ks.Close()
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// lock takes the lease of the migrations, and returns the function
+// releasing it.
+func (m *Migrator) lock(ks Executor) (func(), error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	owner := host + "-" + hex.EncodeToString(b)
+	lease := m.Lease
+	if lease <= 0 {
+		lease = DefaultMigrationLease
+	}
+	applied, err := ks.ExecCAS("INSERT INTO "+MigrationsLockTable+" (name, owner, locked_at) VALUES (?, ?, ?) IF NOT EXISTS USING TTL ?",
+		MigrationsTable, owner, time.Now().UTC(), int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, ErrMigrationsLocked
+	}
+	return func() {
+		_, err := ks.ExecCAS("DELETE FROM "+MigrationsLockTable+" WHERE name = ? IF owner = ?", MigrationsTable, owner)
+		if err != nil {
+			log.Errorf("Failed to release the migrations lease of %s: %s", m.Options.Keyspace, err)
+		}
+	}, nil
+}
+
+func (m *Migrator) applied(ks Executor) (map[int]time.Time, error) {
+	rows, err := ks.Query("SELECT version, applied_at FROM " + MigrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		version, _ := row["version"].(int)
+		at, _ := row["applied_at"].(time.Time)
+		applied[version] = at
+	}
+	return applied, nil
+}
+
+func (m *Migrator) sorted() []Migration {
+	migrations := append([]Migration(nil), m.Migrations...)
+	sort.Sort(byMigrationVersion(migrations))
+	return migrations
+}
+
+func (m *Migrator) exec(ks Executor, mg Migration, cql string) error {
+	for _, stmt := range statements(cql) {
+		log.Debugf(cmd.Colorfy("  > [scylla] migrate "+strconv.Itoa(mg.Version)+" "+stmt, "blue", "", "bold"))
+		if err := ks.Exec(stmt); err != nil {
+			return fmt.Errorf("Migration %d (%s) failed: %s", mg.Version, mg.Name, err)
+		}
+	}
+	return nil
+}
+
+// Up applies the pending migrations in order, and returns the ones
+// applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	ks, err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	defer ks.Close()
+	unlock, err := m.lock(ks)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ks)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, mg := range m.sorted() {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		if err := m.exec(ks, mg, mg.Up); err != nil {
+			return done, err
+		}
+		err := ks.Exec("INSERT INTO "+MigrationsTable+" (version, name, applied_at) VALUES (?, ?, ?)", mg.Version, mg.Name, time.Now().UTC())
+		if err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+	return done, nil
+}
+
+// Down reverts the last n applied migrations, latest first, and returns
+// the ones reverted.
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	ks, err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	defer ks.Close()
+	unlock, err := m.lock(ks)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ks)
+	if err != nil {
+		return nil, err
+	}
+	migrations := m.sorted()
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < n; i-- {
+		mg := migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if strings.TrimSpace(mg.Down) == "" {
+			return done, fmt.Errorf("Migration %d (%s) can't be reverted.", mg.Version, mg.Name)
+		}
+		if err := m.exec(ks, mg, mg.Down); err != nil {
+			return done, err
+		}
+		if err := ks.Exec("DELETE FROM "+MigrationsTable+" WHERE version = ?", mg.Version); err != nil {
+			return done, err
+		}
+		done = append(done, mg)
+	}
+	return done, nil
+}
+
+// Status returns the migrations in order, telling which are applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	ks, err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	defer ks.Close()
+	applied, err := m.applied(ks)
+	if err != nil {
+		return nil, err
+	}
+	migrations := m.sorted()
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, mg := range migrations {
+		at, ok := applied[mg.Version]
+		status = append(status, MigrationStatus{Migration: mg, Applied: ok, AppliedAt: at})
+	}
+	return status, nil
+}
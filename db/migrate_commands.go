@@ -0,0 +1,141 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/megamsys/libgo/cmd"
+	"launchpad.net/gnuflag"
+)
+
+// MigrateCommands are the migrate-up, migrate-down and migrate-status
+// commands of a Migrator, for cmd.Manager:
+//
+//	for _, c := range (db.MigrateCommands{Migrator: m}).Commands() {
+//		manager.Register(c)
+//	}
+type MigrateCommands struct {
+	Migrator *Migrator
+}
+
+func (c MigrateCommands) Commands() []cmd.Command {
+	return []cmd.Command{
+		&migrateUp{c.Migrator},
+		&migrateDown{m: c.Migrator},
+		&migrateStatus{c.Migrator},
+	}
+}
+
+func printMigrations(context *cmd.Context, verb string, migrations []Migration) {
+	for _, mg := range migrations {
+		fmt.Fprintf(context.Stdout, "%s %04d %s.\n", verb, mg.Version, mg.Name)
+	}
+}
+
+type migrateUp struct {
+	m *Migrator
+}
+
+func (c *migrateUp) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "migrate-up",
+		Usage: "migrate-up",
+		Desc:  "Applies the pending migrations of the keyspace.",
+	}
+}
+
+func (c *migrateUp) Run(context *cmd.Context) error {
+	done, err := c.m.Up()
+	printMigrations(context, "Applied", done)
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Fprintln(context.Stdout, "Schema is up to date.")
+	}
+	return nil
+}
+
+type migrateDown struct {
+	m     *Migrator
+	steps int
+	fs    *gnuflag.FlagSet
+}
+
+func (c *migrateDown) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "migrate-down",
+		Usage: "migrate-down [--steps n]",
+		Desc:  "Reverts the last applied migrations of the keyspace, one by default.",
+	}
+}
+
+func (c *migrateDown) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("migrate-down", gnuflag.ExitOnError)
+		c.fs.IntVar(&c.steps, "steps", 1, "Number of migrations to revert.")
+		c.fs.IntVar(&c.steps, "n", 1, "Number of migrations to revert.")
+	}
+	return c.fs
+}
+
+func (c *migrateDown) Run(context *cmd.Context) error {
+	if c.steps < 1 {
+		return fmt.Errorf("Invalid number of steps: %d.", c.steps)
+	}
+	done, err := c.m.Down(c.steps)
+	printMigrations(context, "Reverted", done)
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Fprintln(context.Stdout, "No migration to revert.")
+	}
+	return nil
+}
+
+type migrateStatus struct {
+	m *Migrator
+}
+
+func (c *migrateStatus) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "migrate-status",
+		Usage: "migrate-status",
+		Desc:  "Lists the migrations of the keyspace and when they were applied.",
+	}
+}
+
+func (c *migrateStatus) Run(context *cmd.Context) error {
+	status, err := c.m.Status()
+	if err != nil {
+		return err
+	}
+	t := cmd.NewTable()
+	t.Headers = cmd.Row{"Version", "Name", "Applied"}
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		t.AddRow(cmd.Row{fmt.Sprintf("%04d", s.Version), s.Name, applied})
+	}
+	context.Stdout.Write(t.Bytes())
+	return nil
+}
@@ -0,0 +1,253 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/megamsys/libgo/cmd"
+	"gopkg.in/check.v1"
+)
+
+// fakeCluster records the statements run, and keeps schema_migrations.
+type fakeCluster struct {
+	stmts    []string
+	versions map[int]time.Time
+	fail     string
+	lock     string
+}
+
+type fakeExecutor struct {
+	c        *fakeCluster
+	keyspace string
+	closed   bool
+}
+
+func (e *fakeExecutor) Exec(stmt string, values ...interface{}) error {
+	if e.c.fail != "" && strings.Contains(stmt, e.c.fail) {
+		return errors.New("syntax error")
+	}
+	switch {
+	case strings.HasPrefix(stmt, "INSERT INTO "+MigrationsTable):
+		e.c.versions[values[0].(int)] = values[2].(time.Time)
+	case strings.HasPrefix(stmt, "DELETE FROM "+MigrationsTable):
+		delete(e.c.versions, values[0].(int))
+	default:
+		e.c.stmts = append(e.c.stmts, e.keyspace+": "+stmt)
+	}
+	return nil
+}
+
+func (e *fakeExecutor) Query(stmt string, values ...interface{}) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	for v, at := range e.c.versions {
+		rows = append(rows, map[string]interface{}{"version": v, "applied_at": at})
+	}
+	return rows, nil
+}
+
+func (e *fakeExecutor) ExecCAS(stmt string, values ...interface{}) (bool, error) {
+	switch {
+	case strings.HasPrefix(stmt, "INSERT INTO "+MigrationsLockTable) && e.c.lock == "":
+		e.c.lock = values[1].(string)
+		return true, nil
+	case strings.HasPrefix(stmt, "DELETE FROM "+MigrationsLockTable) && e.c.lock == values[1]:
+		e.c.lock = ""
+		return true, nil
+	}
+	return false, nil
+}
+
+func (e *fakeExecutor) Close() {
+	e.closed = true
+}
+
+var testMigrations = []Migration{
+	{Version: 2, Name: "add_seen", Up: "ALTER TABLE events ADD seen boolean;", Down: "ALTER TABLE events DROP seen;"},
+	{Version: 1, Name: "create_events", Up: "CREATE TABLE events (id text PRIMARY KEY);", Down: "DROP TABLE events;"},
+}
+
+func fakeMigrator(migrations []Migration) (*Migrator, *fakeCluster) {
+	c := &fakeCluster{versions: make(map[int]time.Time)}
+	m := NewMigrator(Options{Hosts: []string{"10.0.0.1"}, Keyspace: "vertice"}, migrations)
+	m.connect = func(ops Options, keyspace string) (Executor, error) {
+		return &fakeExecutor{c: c, keyspace: keyspace}, nil
+	}
+	return m, c
+}
+
+func (s *S) TestStatements(c *check.C) {
+	cql := `-- the events
+CREATE TABLE events (id text PRIMARY KEY, note text); // one
+INSERT INTO events (id, note) VALUES ('1', 'a;b');
+
+`
+	c.Assert(statements(cql), check.DeepEquals, []string{
+		"CREATE TABLE events (id text PRIMARY KEY, note text)",
+		"INSERT INTO events (id, note) VALUES ('1', 'a;b')",
+	})
+	cql = `/* the events; and
+their notes */ CREATE TABLE events (id text, /* key; */ note text);
+DROP TABLE alerts; /* unterminated;`
+	c.Assert(statements(cql), check.DeepEquals, []string{
+		"CREATE TABLE events (id text,   note text)",
+		"DROP TABLE alerts",
+	})
+}
+
+func (s *S) TestReplication(c *check.C) {
+	c.Assert(Replication{}.cql(), check.Equals, "{'class': 'SimpleStrategy', 'replication_factor': 1}")
+	c.Assert(Replication{Factor: 3}.cql(), check.Equals, "{'class': 'SimpleStrategy', 'replication_factor': 3}")
+	r := Replication{DataCenters: map[string]int{"dc2": 2, "dc1": 3}}
+	c.Assert(r.cql(), check.Equals, "{'class': 'NetworkTopologyStrategy', 'dc1': 3, 'dc2': 2}")
+}
+
+func (s *S) TestLoadMigrations(c *check.C) {
+	migrations, err := LoadMigrations("migrations")
+	c.Assert(err, check.IsNil)
+	c.Assert(len(migrations) >= 2, check.Equals, true)
+	c.Assert(migrations[0].Version, check.Equals, 1)
+	c.Assert(migrations[0].Name, check.Equals, "create_events_for_obc")
+	c.Assert(statements(migrations[0].Up), check.HasLen, 1)
+	c.Assert(migrations[0].Down, check.Not(check.Equals), "")
+	for i, m := range migrations {
+		c.Assert(m.Version, check.Equals, i+1)
+	}
+}
+
+func (s *S) TestLoadMigrationsErrors(c *check.C) {
+	dir, err := ioutil.TempDir("", "migrations")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	write := func(name string) {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte("DROP TABLE events;"), 0644), check.IsNil)
+	}
+	write("0001_create_events.down.cql")
+	write("README.md")
+	_, err = LoadMigrations(dir)
+	c.Assert(err, check.ErrorMatches, `Migration 1 \(create_events\) has no up statements.`)
+	write("0001_create_events.up.cql")
+	write("0001_create_alerts.up.cql")
+	_, err = LoadMigrations(dir)
+	c.Assert(err, check.ErrorMatches, "Migration 1 is both .* and .*.")
+}
+
+func (s *S) TestMigratorUp(c *check.C) {
+	m, cluster := fakeMigrator(testMigrations)
+	m.Replication = Replication{Factor: 3}
+	done, err := m.Up()
+	c.Assert(err, check.IsNil)
+	c.Assert(done, check.HasLen, 2)
+	c.Assert(done[0].Version, check.Equals, 1)
+	c.Assert(cluster.stmts, check.DeepEquals, []string{
+		": CREATE KEYSPACE IF NOT EXISTS vertice WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 3}",
+		"vertice: CREATE TABLE IF NOT EXISTS schema_migrations (version int PRIMARY KEY, name text, applied_at timestamp)",
+		"vertice: CREATE TABLE IF NOT EXISTS schema_migrations_lock (name text PRIMARY KEY, owner text, locked_at timestamp)",
+		"vertice: CREATE TABLE events (id text PRIMARY KEY)",
+		"vertice: ALTER TABLE events ADD seen boolean",
+	})
+	c.Assert(cluster.versions, check.HasLen, 2)
+	c.Assert(cluster.lock, check.Equals, "")
+	done, err = m.Up()
+	c.Assert(err, check.IsNil)
+	c.Assert(done, check.HasLen, 0)
+}
+
+func (s *S) TestMigratorLease(c *check.C) {
+	m, cluster := fakeMigrator(testMigrations)
+	cluster.lock = "other-host"
+	done, err := m.Up()
+	c.Assert(err, check.Equals, ErrMigrationsLocked)
+	c.Assert(done, check.HasLen, 0)
+	c.Assert(cluster.versions, check.HasLen, 0)
+	_, err = m.Down(1)
+	c.Assert(err, check.Equals, ErrMigrationsLocked)
+	cluster.lock = ""
+	cluster.fail = "ALTER"
+	_, err = m.Up()
+	c.Assert(err, check.NotNil)
+	c.Assert(cluster.lock, check.Equals, "")
+}
+
+func (s *S) TestMigratorUpFailure(c *check.C) {
+	m, cluster := fakeMigrator(testMigrations)
+	cluster.fail = "ALTER"
+	done, err := m.Up()
+	c.Assert(err, check.ErrorMatches, `Migration 2 \(add_seen\) failed: syntax error`)
+	c.Assert(done, check.HasLen, 1)
+	c.Assert(cluster.versions, check.HasLen, 1)
+	_, ok := cluster.versions[2]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestMigratorDown(c *check.C) {
+	m, cluster := fakeMigrator(testMigrations)
+	_, err := m.Up()
+	c.Assert(err, check.IsNil)
+	cluster.stmts = nil
+	done, err := m.Down(1)
+	c.Assert(err, check.IsNil)
+	c.Assert(done, check.HasLen, 1)
+	c.Assert(done[0].Version, check.Equals, 2)
+	c.Assert(cluster.stmts[len(cluster.stmts)-1], check.Equals, "vertice: ALTER TABLE events DROP seen")
+	c.Assert(cluster.versions, check.HasLen, 1)
+	done, err = m.Down(5)
+	c.Assert(err, check.IsNil)
+	c.Assert(done, check.HasLen, 1)
+	c.Assert(cluster.versions, check.HasLen, 0)
+	m.Migrations = []Migration{{Version: 1, Name: "create_events", Up: "CREATE TABLE events (id text PRIMARY KEY);"}}
+	_, err = m.Up()
+	c.Assert(err, check.IsNil)
+	_, err = m.Down(1)
+	c.Assert(err, check.ErrorMatches, `Migration 1 \(create_events\) can't be reverted.`)
+}
+
+func (s *S) TestMigratorNeedsKeyspace(c *check.C) {
+	m, _ := fakeMigrator(testMigrations)
+	m.Options.Keyspace = ""
+	_, err := m.Status()
+	c.Assert(err, check.ErrorMatches, "Migrations need a keyspace.")
+}
+
+func (s *S) TestMigrateCommands(c *check.C) {
+	m, cluster := fakeMigrator(testMigrations)
+	commands := MigrateCommands{Migrator: m}.Commands()
+	c.Assert(commands, check.HasLen, 3)
+	var stdout bytes.Buffer
+	context := &cmd.Context{Stdout: &stdout, Stderr: &stdout}
+	c.Assert(commands[0].Info().Name, check.Equals, "migrate-up")
+	c.Assert(commands[0].Run(context), check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Applied 0001 create_events.\nApplied 0002 add_seen.\n")
+	stdout.Reset()
+	c.Assert(commands[0].Run(context), check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Schema is up to date.\n")
+	stdout.Reset()
+	down := commands[1].(cmd.FlaggedCommand)
+	c.Assert(down.Flags().Parse(true, []string{"--steps", "2"}), check.IsNil)
+	c.Assert(down.Run(context), check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Reverted 0002 add_seen.\nReverted 0001 create_events.\n")
+	stdout.Reset()
+	cluster.versions[1] = time.Date(2016, 5, 3, 10, 0, 0, 0, time.UTC)
+	c.Assert(commands[2].Run(context), check.IsNil)
+	c.Assert(stdout.String(), check.Matches, `(?s).*0001 .*create_events.*2016-05-03T10:00:00Z.*0002 .*add_seen .*pending.*`)
+}
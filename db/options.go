@@ -0,0 +1,163 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/gocassa"
+	"github.com/megamsys/gocql"
+	"github.com/megamsys/libgo/cmd"
+)
+
+// ErrTimeout is returned when a query does not complete within its
+// Options.Timeout.
+var ErrTimeout = errors.New("Query timed out.")
+
+// Defaults are the options of the queries of a keyspace, used when the
+// Options of a call leave them unset.
+type Defaults struct {
+	// Consistency of the statements run on the gocql session of the
+	// keyspace: pages, batches and migrations. The pinned gocassa can't be
+	// given one, so its operations use the consistency of its connection.
+	Consistency *gocql.Consistency
+	Timeout     time.Duration
+
+	// Attempts after the first one of the reads, and of the writes marked
+	// Idempotent.
+	Retries int
+
+	// Expiration of the rows written in the given tables, none for the
+	// others.
+	TTLs map[string]time.Duration
+
+	// Puts the driver in debug mode, logging every statement.
+	Debug bool
+}
+
+var (
+	defaults   = make(map[string]Defaults)
+	defaultsMu sync.RWMutex
+)
+
+// SetDefaults sets the defaults of the queries of the given keyspace.
+func SetDefaults(keyspace string, d Defaults) {
+	defaultsMu.Lock()
+	defaults[keyspace] = d
+	defaultsMu.Unlock()
+	DefaultPool.debug(keyspace, d.Debug)
+}
+
+func defaultsFor(keyspace string) Defaults {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return defaults[keyspace]
+}
+
+// Consistency returns a pointer to c, to be set in Options or Defaults.
+func Consistency(c gocql.Consistency) *gocql.Consistency {
+	return &c
+}
+
+// Retries returns a pointer to n, to be set in Options: zero disables the
+// retries of the keyspace Defaults.
+func Retries(n int) *int {
+	return &n
+}
+
+// withDefaults fills the unset query options with the keyspace defaults.
+func (ops Options) withDefaults() Options {
+	d := defaultsFor(ops.Keyspace)
+	if ops.Consistency == nil {
+		ops.Consistency = d.Consistency
+	}
+	if ops.Timeout == 0 {
+		ops.Timeout = d.Timeout
+	}
+	if ops.Retries == nil {
+		ops.Retries = Retries(d.Retries)
+	}
+	if ops.TTL == 0 {
+		ops.TTL = d.TTLs[ops.TableName]
+	}
+	return ops
+}
+
+// run executes the operation with the query options, retrying it when it
+// fails and is idempotent. The operation is abandoned, not cancelled, when
+// the context is done or the timeout expires, so it is not retried then: it
+// may still be running.
+func run(ctx context.Context, ops Options, op gocassa.Op, idempotent bool) error {
+	ops = ops.withDefaults()
+	op = op.WithOptions(gocassa.Options{TTL: ops.TTL})
+	for attempt := 0; ; attempt++ {
+		err := runOnce(ctx, ops.Timeout, op)
+		if err == nil || !idempotent || attempt >= *ops.Retries || !retryable(ctx, err) {
+			return err
+		}
+		log.Debugf(cmd.Colorfy("  > [scylla] retry "+ops.TableName+": "+err.Error(), "yellow", "", "bold"))
+	}
+}
+
+func runOnce(ctx context.Context, timeout time.Duration, op gocassa.Op) error {
+	return await(ctx, timeout, op.Run)
+}
+
+// await calls fn, giving up on it when the context is done or the timeout
+// expires: fn is abandoned, not cancelled, as the pinned drivers take no
+// context.
+func await(ctx context.Context, timeout time.Duration, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if timeout == 0 && ctx.Done() == nil {
+		return fn()
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-expired:
+		return ErrTimeout
+	}
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || err == ErrTimeout {
+		return false
+	}
+	switch err.(type) {
+	case gocassa.RowNotFoundError, *gocassa.RowNotFoundError:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/megamsys/gocassa"
+	"github.com/megamsys/gocql"
+	"gopkg.in/check.v1"
+)
+
+type fakeOp struct {
+	gocassa.Op
+	errs    []error
+	calls   int
+	delay   time.Duration
+	options gocassa.Options
+	fill    func()
+}
+
+func (o *fakeOp) WithOptions(opts gocassa.Options) gocassa.Op {
+	o.options = opts
+	return o
+}
+
+func (o *fakeOp) Run() error {
+	time.Sleep(o.delay)
+	o.calls++
+	if o.fill != nil {
+		o.fill()
+	}
+	if len(o.errs) == 0 {
+		return nil
+	}
+	err := o.errs[0]
+	o.errs = o.errs[1:]
+	return err
+}
+
+func (s *S) TestRunAppliesDefaults(c *check.C) {
+	SetDefaults("defaults_test", Defaults{Consistency: Consistency(gocql.Quorum), TTLs: map[string]time.Duration{"events": time.Hour}})
+	defer SetDefaults("defaults_test", Defaults{})
+	op := &fakeOp{}
+	err := run(context.Background(), Options{Keyspace: "defaults_test", TableName: "events"}, op, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(op.options.TTL, check.Equals, time.Hour)
+	c.Assert(*Options{Keyspace: "defaults_test"}.withDefaults().Consistency, check.Equals, gocql.Quorum)
+	op = &fakeOp{}
+	err = run(context.Background(), Options{Keyspace: "defaults_test", TableName: "journal"}, op, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(op.options.TTL, check.Equals, time.Duration(0))
+	op = &fakeOp{}
+	err = run(context.Background(), Options{Keyspace: "defaults_test", TableName: "events", Consistency: Consistency(gocql.One), TTL: time.Minute}, op, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(op.options.TTL, check.Equals, time.Minute)
+	c.Assert(*Options{Keyspace: "defaults_test", Consistency: Consistency(gocql.One)}.withDefaults().Consistency, check.Equals, gocql.One)
+}
+
+func (s *S) TestRunRetries(c *check.C) {
+	unavailable := errors.New("unavailable")
+	op := &fakeOp{errs: []error{unavailable, unavailable}}
+	err := run(context.Background(), Options{Retries: Retries(2)}, op, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(op.calls, check.Equals, 3)
+	op = &fakeOp{errs: []error{unavailable, unavailable}}
+	err = run(context.Background(), Options{Retries: Retries(1)}, op, true)
+	c.Assert(err, check.Equals, unavailable)
+	c.Assert(op.calls, check.Equals, 2)
+	op = &fakeOp{errs: []error{gocassa.RowNotFoundError{}}}
+	err = run(context.Background(), Options{Retries: Retries(3)}, op, true)
+	c.Assert(err, check.FitsTypeOf, gocassa.RowNotFoundError{})
+	c.Assert(op.calls, check.Equals, 1)
+	op = &fakeOp{errs: []error{unavailable}}
+	err = run(context.Background(), Options{Retries: Retries(3)}, op, false)
+	c.Assert(err, check.Equals, unavailable)
+	c.Assert(op.calls, check.Equals, 1)
+}
+
+func (s *S) TestRunRetriesOverrideDefaults(c *check.C) {
+	SetDefaults("defaults_test", Defaults{Retries: 2})
+	defer SetDefaults("defaults_test", Defaults{})
+	unavailable := errors.New("unavailable")
+	op := &fakeOp{errs: []error{unavailable, unavailable}}
+	err := run(context.Background(), Options{Keyspace: "defaults_test"}, op, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(op.calls, check.Equals, 3)
+	op = &fakeOp{errs: []error{unavailable, unavailable}}
+	err = run(context.Background(), Options{Keyspace: "defaults_test", Retries: Retries(0)}, op, true)
+	c.Assert(err, check.Equals, unavailable)
+	c.Assert(op.calls, check.Equals, 1)
+}
+
+func (s *S) TestRunTimeout(c *check.C) {
+	op := &fakeOp{delay: time.Second}
+	start := time.Now()
+	err := run(context.Background(), Options{Timeout: 10 * time.Millisecond, Retries: Retries(3)}, op, true)
+	c.Assert(err, check.Equals, ErrTimeout)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+	c.Assert(retryable(context.Background(), ErrTimeout), check.Equals, false)
+}
+
+func (s *S) TestRunContext(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	op := &fakeOp{}
+	err := run(ctx, Options{Retries: Retries(3)}, op, true)
+	c.Assert(err, check.Equals, context.Canceled)
+	c.Assert(op.calls, check.Equals, 0)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	op = &fakeOp{delay: time.Second}
+	err = run(ctx, Options{Retries: Retries(3)}, op, true)
+	c.Assert(err, check.Equals, context.DeadlineExceeded)
+}
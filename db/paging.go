@@ -0,0 +1,383 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/libgo/cmd"
+)
+
+// ErrInvalidCursor is returned for a cursor not returned by FetchPagedb.
+var ErrInvalidCursor = errors.New("Invalid page cursor.")
+
+// DefaultPageSize is the size of the pages of a Page without one.
+const DefaultPageSize = 500
+
+// Page selects a page of the rows matching the PksClauses of the options.
+type Page struct {
+	Size int
+
+	// The position of the page, returned by the read of the previous page.
+	// The first page has none.
+	Cursor string
+
+	// Restricts the rows to the partitions of the range, when not nil.
+	Range *TokenRange
+}
+
+// check rejects a token range on a table without a partition key, which
+// would otherwise read every partition.
+func (p Page) check(ops Options) error {
+	if p.Range != nil && len(ops.Pks) == 0 {
+		return fmt.Errorf("Token range of %s without a partition key.", ops.TableName)
+	}
+	return nil
+}
+
+func (p Page) size() int {
+	if p.Size > 0 {
+		return p.Size
+	}
+	return DefaultPageSize
+}
+
+// TokenRange is the range of the partition tokens greater than Start and
+// up to End.
+type TokenRange struct {
+	Start int64
+	End   int64
+}
+
+func (r TokenRange) contains(token int64) bool {
+	return token > r.Start && token <= r.End
+}
+
+// TokenRanges splits the token ring of the Murmur3 partitioner in n ranges,
+// to be scanned separately.
+func TokenRanges(n int) []TokenRange {
+	if n < 1 {
+		n = 1
+	}
+	step := uint64(math.MaxUint64) / uint64(n)
+	ranges := make([]TokenRange, n)
+	start := int64(math.MinInt64)
+	for i := range ranges {
+		end := int64(uint64(1)<<63 + uint64(i+1)*step)
+		if i == n-1 {
+			end = math.MaxInt64
+		}
+		ranges[i] = TokenRange{Start: start, End: end}
+		start = end
+	}
+	return ranges
+}
+
+func encodeCursor(state []byte) string {
+	if len(state) == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(state)
+}
+
+func decodeCursor(cursor string) ([]byte, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	state, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return state, nil
+}
+
+// FetchPagedb reads a page of the rows matching the PksClauses of ops into
+// data, a pointer to a slice of the model, and returns the cursor of the
+// next page, empty after the last one.
+func FetchPagedb(ctx context.Context, ops Options, page Page, data interface{}) (string, error) {
+	return CurrentBackend().FetchPage(ctx, ops, page, data)
+}
+
+// selectPage returns the statement reading a page of the given columns,
+// filtering only when the clauses are not a partition key followed by
+// a prefix of the clustering columns.
+func selectPage(ops Options, columns []string, r *TokenRange) (string, []interface{}) {
+	var (
+		where  []string
+		values []interface{}
+	)
+	clauses := make([]string, 0, len(ops.PksClauses))
+	for c := range ops.PksClauses {
+		clauses = append(clauses, c)
+	}
+	sort.Strings(clauses)
+	for _, c := range clauses {
+		where = append(where, c+" = ?")
+		values = append(values, ops.PksClauses[c])
+	}
+	if r != nil {
+		token := "token(" + strings.Join(ops.Pks, ", ") + ")"
+		where = append(where, token+" > ?", token+" <= ?")
+		values = append(values, r.Start, r.End)
+	}
+	stmt := "SELECT * FROM " + ops.TableName
+	if len(columns) > 0 {
+		stmt = "SELECT " + strings.Join(columns, ", ") + " FROM " + ops.TableName
+	}
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	if filtering(ops, clauses) {
+		stmt += " ALLOW FILTERING"
+	}
+	return stmt, values
+}
+
+func filtering(ops Options, clauses []string) bool {
+	if len(clauses) == 0 {
+		return false
+	}
+	set := make(map[string]bool, len(clauses))
+	for _, c := range clauses {
+		set[c] = true
+	}
+	for _, k := range ops.Pks {
+		if !set[k] {
+			return true
+		}
+		delete(set, k)
+	}
+	for _, k := range ops.Ccms {
+		if len(set) == 0 {
+			break
+		}
+		if !set[k] {
+			return true
+		}
+		delete(set, k)
+	}
+	return len(set) > 0
+}
+
+// columnsOfSlice returns the columns of the models of data, a pointer to
+// a slice of them.
+func columnsOfSlice(data interface{}) ([]string, error) {
+	typ := reflect.TypeOf(data)
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("Expected a pointer to a slice, got %T.", data)
+	}
+	typ = typ.Elem().Elem()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	var columns []string
+	for i := 0; i < typ.NumField(); i++ {
+		if c, ok := columnOf(typ.Field(i)); ok {
+			columns = append(columns, c)
+		}
+	}
+	return columns, nil
+}
+
+func (scyllaBackend) FetchPage(ctx context.Context, ops Options, page Page, data interface{}) (string, error) {
+	if err := page.check(ops); err != nil {
+		return "", err
+	}
+	ops = ops.withDefaults()
+	state, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return "", err
+	}
+	columns, err := columnsOfSlice(data)
+	if err != nil {
+		return "", err
+	}
+	t, err := newDBConn(ops)
+	if err != nil {
+		return "", err
+	}
+	session, err := t.Session()
+	if err != nil {
+		return "", err
+	}
+	stmt, values := selectPage(ops, columns, page.Range)
+	log.Debugf("%s (%s)", cmd.Colorfy("  > [scylla] page", "blue", "", "bold"), stmt)
+	q := session.Query(stmt, values...).PageSize(page.size()).PageState(state)
+	if ops.Consistency != nil {
+		q = q.Consistency(*ops.Consistency)
+	}
+	var (
+		rows []map[string]interface{}
+		next []byte
+	)
+	err = await(ctx, ops.Timeout, func() error {
+		iter := q.Iter()
+		for {
+			row := make(map[string]interface{})
+			if !iter.MapScan(row) {
+				break
+			}
+			rows = append(rows, row)
+		}
+		next = iter.PageState()
+		return iter.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+	return encodeCursor(next), decodeRows(rows, data)
+}
+
+// RowIterator reads the rows of a query one at a time, a page at a time,
+// without loading them all in memory.
+type RowIterator struct {
+	ctx  context.Context
+	ops  Options
+	page Page
+	rows reflect.Value
+	i    int
+	done bool
+	err  error
+}
+
+// Iteratedb returns an iterator on the rows matching the PksClauses of
+// ops, of the type of model, read from the given page on.
+func Iteratedb(ctx context.Context, ops Options, page Page, model interface{}) *RowIterator {
+	typ := reflect.TypeOf(model)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	it := &RowIterator{ctx: ctx, ops: ops, page: page}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		it.err = fmt.Errorf("Model %T is not a struct.", model)
+		return it
+	}
+	it.rows = reflect.New(reflect.SliceOf(typ))
+	return it
+}
+
+// Next reads the next row into v, a pointer to the model, and tells
+// whether there was one.
+func (it *RowIterator) Next(v interface{}) bool {
+	for it.err == nil && it.i >= it.rows.Elem().Len() {
+		if it.done {
+			return false
+		}
+		it.i = 0
+		next, err := FetchPagedb(it.ctx, it.ops, it.page, it.rows.Interface())
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page.Cursor = next
+		it.done = next == ""
+	}
+	if it.err != nil {
+		return false
+	}
+	out := reflect.ValueOf(v)
+	if out.Kind() != reflect.Ptr || out.Elem().Type() != it.rows.Elem().Type().Elem() {
+		it.err = fmt.Errorf("Expected a pointer to %s, got %T.", it.rows.Elem().Type().Elem(), v)
+		return false
+	}
+	out.Elem().Set(it.rows.Elem().Index(it.i))
+	it.i++
+	return true
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *RowIterator) Err() error {
+	return it.err
+}
+
+// Scandb reads every row of the table of ops, of the type of model, the
+// token ranges being read by up to workers goroutines. fn is called with a
+// pointer to each row, concurrently when there are several workers. The
+// scan stops at the first error.
+func Scandb(ctx context.Context, ops Options, model interface{}, ranges []TokenRange, size, workers int, fn func(row interface{}) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	typ := reflect.TypeOf(model)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("Model %T is not a struct.", model)
+	}
+	for i := range ranges {
+		if err := (Page{Range: &ranges[i]}).check(ops); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		todo     = make(chan TokenRange, len(ranges))
+	)
+	for _, r := range ranges {
+		todo <- r
+	}
+	close(todo)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range todo {
+				r := r
+				it := Iteratedb(ctx, ops, Page{Size: size, Range: &r}, model)
+				for {
+					row := reflect.New(typ)
+					if !it.Next(row.Interface()) {
+						break
+					}
+					if err := fn(row.Interface()); err != nil {
+						fail(err)
+						return
+					}
+				}
+				if err := it.Err(); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
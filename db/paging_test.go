@@ -0,0 +1,199 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTokenRanges(c *check.C) {
+	ranges := TokenRanges(4)
+	c.Assert(ranges, check.HasLen, 4)
+	c.Assert(ranges[0].Start, check.Equals, int64(math.MinInt64))
+	c.Assert(ranges[3].End, check.Equals, int64(math.MaxInt64))
+	for i := 1; i < len(ranges); i++ {
+		c.Assert(ranges[i].Start, check.Equals, ranges[i-1].End)
+		c.Assert(ranges[i].Start < ranges[i].End, check.Equals, true)
+	}
+	c.Assert(ranges[1].End <= 0 && ranges[2].End > 0, check.Equals, true)
+	c.Assert(TokenRanges(0), check.DeepEquals, []TokenRange{{math.MinInt64, math.MaxInt64}})
+}
+
+func (s *S) TestSelectPage(c *check.C) {
+	ops := Options{TableName: "events_for_obc", Pks: []string{"event_type", "created_at"}, Ccms: []string{"host_id", "account_id"}}
+	stmt, values := selectPage(ops, []string{"id", "event_type"}, nil)
+	c.Assert(stmt, check.Equals, "SELECT id, event_type FROM events_for_obc")
+	c.Assert(values, check.HasLen, 0)
+	ops.PksClauses = map[string]interface{}{"account_id": "info@megam.io"}
+	stmt, values = selectPage(ops, nil, nil)
+	c.Assert(stmt, check.Equals, "SELECT * FROM events_for_obc WHERE account_id = ? ALLOW FILTERING")
+	c.Assert(values, check.DeepEquals, []interface{}{"info@megam.io"})
+	ops.PksClauses = map[string]interface{}{"event_type": "launched", "created_at": 1, "host_id": "h1"}
+	stmt, values = selectPage(ops, nil, nil)
+	c.Assert(stmt, check.Equals, "SELECT * FROM events_for_obc WHERE created_at = ? AND event_type = ? AND host_id = ?")
+	c.Assert(values, check.DeepEquals, []interface{}{1, "launched", "h1"})
+	ops.PksClauses = map[string]interface{}{"event_type": "launched", "created_at": 1, "account_id": "info@megam.io"}
+	stmt, _ = selectPage(ops, nil, nil)
+	c.Assert(stmt, check.Matches, ".* ALLOW FILTERING")
+	ops.PksClauses = nil
+	stmt, values = selectPage(ops, nil, &TokenRange{Start: -10, End: 10})
+	c.Assert(stmt, check.Equals, "SELECT * FROM events_for_obc WHERE token(event_type, created_at) > ? AND token(event_type, created_at) <= ?")
+	c.Assert(values, check.DeepEquals, []interface{}{int64(-10), int64(10)})
+}
+
+func storeReadings(c *check.C, m *MemoryBackend, hosts, seqs int) {
+	for h := 0; h < hosts; h++ {
+		for i := 0; i < seqs; i++ {
+			r := testReading{HostId: fmt.Sprintf("h%d", h), Seq: i}
+			c.Assert(m.Store(context.Background(), readingOptions(), r), check.IsNil)
+		}
+	}
+}
+
+func (s *S) TestMemoryBackendFetchPage(c *check.C) {
+	m := NewMemoryBackend()
+	storeReadings(c, m, 2, 5)
+	ops := readingOptions()
+	ops.PksClauses = map[string]interface{}{"host_id": "h1"}
+	var (
+		seqs   []int
+		cursor string
+		pages  int
+	)
+	for {
+		var readings []testReading
+		next, err := m.FetchPage(context.Background(), ops, Page{Size: 2, Cursor: cursor}, &readings)
+		c.Assert(err, check.IsNil)
+		pages++
+		for _, r := range readings {
+			seqs = append(seqs, r.Seq)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	c.Assert(pages, check.Equals, 3)
+	c.Assert(seqs, check.DeepEquals, []int{0, 1, 2, 3, 4})
+	var readings []testReading
+	_, err := m.FetchPage(context.Background(), ops, Page{Cursor: "!"}, &readings)
+	c.Assert(err, check.Equals, ErrInvalidCursor)
+}
+
+func (s *S) TestIteratedb(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	storeReadings(c, m, 3, 4)
+	it := Iteratedb(context.Background(), readingOptions(), Page{Size: 5}, testReading{})
+	var (
+		r     testReading
+		count int
+	)
+	for it.Next(&r) {
+		count++
+	}
+	c.Assert(it.Err(), check.IsNil)
+	c.Assert(count, check.Equals, 12)
+	it = Iteratedb(context.Background(), readingOptions(), Page{}, testReading{})
+	c.Assert(it.Next(&testEvent{}), check.Equals, false)
+	c.Assert(it.Err(), check.ErrorMatches, "Expected a pointer to db.testReading, got \\*db.testEvent.")
+	it = Iteratedb(context.Background(), readingOptions(), Page{}, "readings")
+	c.Assert(it.Next(&r), check.Equals, false)
+	c.Assert(it.Err(), check.ErrorMatches, "Model string is not a struct.")
+}
+
+func (s *S) TestScandb(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	storeReadings(c, m, 20, 3)
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]int)
+	)
+	err := Scandb(context.Background(), readingOptions(), testReading{}, TokenRanges(8), 2, 3, func(row interface{}) error {
+		r := row.(*testReading)
+		mu.Lock()
+		seen[r.HostId]++
+		mu.Unlock()
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(seen, check.HasLen, 20)
+	for _, n := range seen {
+		c.Assert(n, check.Equals, 3)
+	}
+	stop := errors.New("stop")
+	err = Scandb(context.Background(), readingOptions(), testReading{}, TokenRanges(8), 2, 3, func(row interface{}) error {
+		return stop
+	})
+	c.Assert(err, check.Equals, stop)
+	ops := readingOptions()
+	ops.Pks = nil
+	err = Scandb(context.Background(), ops, testReading{}, TokenRanges(8), 2, 3, func(row interface{}) error {
+		return nil
+	})
+	c.Assert(err, check.ErrorMatches, "Token range of readings without a partition key.")
+}
+
+func (s *S) TestFetchPageRangeWithoutPartitionKey(c *check.C) {
+	ops := readingOptions()
+	ops.Pks = nil
+	page := Page{Range: &TokenRange{Start: -10, End: 10}}
+	var readings []testReading
+	_, err := NewMemoryBackend().FetchPage(context.Background(), ops, page, &readings)
+	c.Assert(err, check.ErrorMatches, "Token range of readings without a partition key.")
+	_, err = scyllaBackend{}.FetchPage(context.Background(), ops, page, &readings)
+	c.Assert(err, check.ErrorMatches, "Token range of readings without a partition key.")
+}
+
+func (s *S) TestRepositoryPage(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	r, err := NewRepository(testEvent{}, Options{Keyspace: "memory_test"})
+	c.Assert(err, check.IsNil)
+	ctx := context.Background()
+	for _, host := range []string{"h1", "h2", "h3"} {
+		c.Assert(r.Put(ctx, testEvent{EventType: "launched", HostId: host, AccountId: "info@megam.io"}), check.IsNil)
+	}
+	where := map[string]interface{}{"account_id": "info@megam.io"}
+	var events []testEvent
+	next, err := r.Page(ctx, where, Page{Size: 2}, &events)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 2)
+	c.Assert(next, check.Not(check.Equals), "")
+	next, err = r.Page(ctx, where, Page{Size: 2, Cursor: next}, &events)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].HostId, check.Equals, "h3")
+	c.Assert(next, check.Equals, "")
+	it := r.Iterate(ctx, map[string]interface{}{"email": "info@megam.io"}, Page{})
+	c.Assert(it.Next(&testEvent{}), check.Equals, false)
+	c.Assert(it.Err(), check.ErrorMatches, "Unknown column email of test_event.")
+	count := 0
+	err = r.Scan(ctx, TokenRanges(2), 1, 1, func(row interface{}) error {
+		count++
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 3)
+}
@@ -0,0 +1,186 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/libgo/cmd"
+	"github.com/megamsys/libgo/hc"
+)
+
+// sessionKey identifies the sessions that can be shared.
+type sessionKey struct {
+	hosts    string
+	keyspace string
+	username string
+	password string
+}
+
+func keyOf(ops Options) sessionKey {
+	hosts := append([]string(nil), ops.Hosts...)
+	sort.Strings(hosts)
+	return sessionKey{
+		hosts:    strings.Join(hosts, ","),
+		keyspace: ops.Keyspace,
+		username: ops.Username,
+		password: ops.Password,
+	}
+}
+
+// Pool keeps a long-lived session for each set of hosts, keyspace and
+// credentials. The package-level functions use DefaultPool.
+type Pool struct {
+	mu       sync.Mutex
+	sessions map[sessionKey]*ScyllaDB
+	dialing  map[sessionKey]*dial
+	connect  func(ScyllaDBOpts) (*ScyllaDB, error)
+}
+
+// dial is a connection in progress, shared by the callers asking for its
+// session meanwhile.
+type dial struct {
+	done    chan struct{}
+	session *ScyllaDB
+	err     error
+}
+
+var DefaultPool = NewPool()
+
+func NewPool() *Pool {
+	return &Pool{
+		sessions: make(map[sessionKey]*ScyllaDB),
+		dialing:  make(map[sessionKey]*dial),
+		connect:  newScyllaDB,
+	}
+}
+
+// Get returns the session of the given options, connecting on first use.
+// The pool is not locked while connecting, so only the callers asking for
+// the same session wait for it.
+func (p *Pool) Get(ops Options) (*ScyllaDB, error) {
+	key := keyOf(ops)
+	p.mu.Lock()
+	if s, ok := p.sessions[key]; ok {
+		p.mu.Unlock()
+		return s, nil
+	}
+	if d, ok := p.dialing[key]; ok {
+		p.mu.Unlock()
+		<-d.done
+		return d.session, d.err
+	}
+	d := &dial{done: make(chan struct{})}
+	p.dialing[key] = d
+	p.mu.Unlock()
+	d.session, d.err = p.connect(ScyllaDBOpts{
+		KeySpaceName: ops.Keyspace,
+		NodeIps:      ops.Hosts,
+		Username:     ops.Username,
+		Password:     ops.Password,
+		Debug:        defaultsFor(ops.Keyspace).Debug,
+	})
+	p.mu.Lock()
+	delete(p.dialing, key)
+	if d.err == nil {
+		log.Debugf(cmd.Colorfy("  > [scylla] pooled session "+key.keyspace+"@"+key.hosts, "blue", "", "bold"))
+		p.sessions[key] = d.session
+	}
+	p.mu.Unlock()
+	close(d.done)
+	return d.session, d.err
+}
+
+// Len returns the number of open sessions.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sessions)
+}
+
+// Check pings every session. The failing ones are closed and dropped, so
+// the next call reconnects.
+func (p *Pool) Check() (interface{}, error) {
+	p.mu.Lock()
+	sessions := make(map[sessionKey]*ScyllaDB, len(p.sessions))
+	for k, s := range p.sessions {
+		sessions[k] = s
+	}
+	p.mu.Unlock()
+	raw := make(map[string]string)
+	var failed []string
+	for k, s := range sessions {
+		name := k.keyspace + "@" + k.hosts
+		if _, err := s.KS.Tables(); err != nil {
+			raw[name] = err.Error()
+			failed = append(failed, name)
+			p.drop(k, s)
+			continue
+		}
+		raw[name] = "ok"
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return raw, fmt.Errorf("scylla sessions failed: %s", strings.Join(failed, ", "))
+	}
+	return raw, nil
+}
+
+func (p *Pool) drop(key sessionKey, s *ScyllaDB) {
+	p.mu.Lock()
+	if p.sessions[key] == s {
+		delete(p.sessions, key)
+	}
+	p.mu.Unlock()
+	s.Close()
+}
+
+// debug sets the debug mode of the sessions of the given keyspace.
+func (p *Pool) debug(keyspace string, debug bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, s := range p.sessions {
+		if k.keyspace == keyspace {
+			s.KS.DebugMode(debug)
+		}
+	}
+}
+
+// Register adds the pool to the health checkers, under the given name.
+func (p *Pool) Register(name string) {
+	hc.AddChecker(name, p.Check)
+}
+
+// Close closes every session of the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	sessions := p.sessions
+	p.sessions = make(map[sessionKey]*ScyllaDB)
+	p.mu.Unlock()
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+// Close closes the sessions of DefaultPool, on shutdown.
+func Close() {
+	DefaultPool.Close()
+}
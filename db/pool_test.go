@@ -0,0 +1,148 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/megamsys/gocassa"
+	"gopkg.in/check.v1"
+)
+
+type fakeKeySpace struct {
+	gocassa.KeySpace
+	name   string
+	err    error
+	closed bool
+	table  *fakeTable
+}
+
+func (k *fakeKeySpace) Tables() ([]string, error) {
+	return nil, k.err
+}
+
+func (k *fakeKeySpace) Close() {
+	k.closed = true
+}
+
+func fakePool() (*Pool, *[]*fakeKeySpace) {
+	var opened []*fakeKeySpace
+	p := NewPool()
+	p.connect = func(opts ScyllaDBOpts) (*ScyllaDB, error) {
+		ks := &fakeKeySpace{name: opts.KeySpaceName}
+		opened = append(opened, ks)
+		return &ScyllaDB{NodeIps: opts.NodeIps, KS: ks}, nil
+	}
+	return p, &opened
+}
+
+func (s *S) TestPoolReusesSessions(c *check.C) {
+	p, opened := fakePool()
+	ops := Options{Hosts: []string{"10.0.0.2", "10.0.0.1"}, Keyspace: "vertice", Username: "u", Password: "p"}
+	s1, err := p.Get(ops)
+	c.Assert(err, check.IsNil)
+	ops.Hosts = []string{"10.0.0.1", "10.0.0.2"}
+	ops.TableName = "events_for_obc"
+	s2, err := p.Get(ops)
+	c.Assert(err, check.IsNil)
+	c.Assert(s2, check.Equals, s1)
+	ops.Keyspace = "megdc"
+	s3, err := p.Get(ops)
+	c.Assert(err, check.IsNil)
+	c.Assert(s3, check.Not(check.Equals), s1)
+	c.Assert(*opened, check.HasLen, 2)
+	c.Assert(p.Len(), check.Equals, 2)
+	p.Close()
+	c.Assert(p.Len(), check.Equals, 0)
+	c.Assert((*opened)[0].closed, check.Equals, true)
+	c.Assert((*opened)[1].closed, check.Equals, true)
+}
+
+func (s *S) TestPoolConnectError(c *check.C) {
+	p := NewPool()
+	p.connect = func(opts ScyllaDBOpts) (*ScyllaDB, error) {
+		return nil, errors.New("no hosts available")
+	}
+	_, err := p.Get(Options{Keyspace: "vertice"})
+	c.Assert(err, check.ErrorMatches, "no hosts available")
+	c.Assert(p.Len(), check.Equals, 0)
+}
+
+func (s *S) TestPoolCheckDropsFailedSessions(c *check.C) {
+	p, opened := fakePool()
+	_, err := p.Get(Options{Hosts: []string{"10.0.0.1"}, Keyspace: "vertice"})
+	c.Assert(err, check.IsNil)
+	_, err = p.Get(Options{Hosts: []string{"10.0.0.1"}, Keyspace: "megdc"})
+	c.Assert(err, check.IsNil)
+	raw, err := p.Check()
+	c.Assert(err, check.IsNil)
+	c.Assert(raw, check.DeepEquals, map[string]string{"vertice@10.0.0.1": "ok", "megdc@10.0.0.1": "ok"})
+	(*opened)[1].err = errors.New("connection refused")
+	raw, err = p.Check()
+	c.Assert(err, check.ErrorMatches, "scylla sessions failed: megdc@10.0.0.1")
+	c.Assert(raw, check.DeepEquals, map[string]string{"vertice@10.0.0.1": "ok", "megdc@10.0.0.1": "connection refused"})
+	c.Assert((*opened)[1].closed, check.Equals, true)
+	c.Assert(p.Len(), check.Equals, 1)
+	_, err = p.Get(Options{Hosts: []string{"10.0.0.1"}, Keyspace: "megdc"})
+	c.Assert(err, check.IsNil)
+	c.Assert(*opened, check.HasLen, 3)
+}
+
+func (s *S) TestPoolConnectsOutsideTheLock(c *check.C) {
+	var (
+		mu     sync.Mutex
+		dials  = make(map[string]int)
+		unlock = make(chan struct{})
+	)
+	p := NewPool()
+	p.connect = func(opts ScyllaDBOpts) (*ScyllaDB, error) {
+		mu.Lock()
+		dials[opts.KeySpaceName]++
+		mu.Unlock()
+		if opts.KeySpaceName == "slow" {
+			<-unlock
+		}
+		return &ScyllaDB{KS: &fakeKeySpace{name: opts.KeySpaceName}}, nil
+	}
+	slow := make(chan *ScyllaDB, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			s, _ := p.Get(Options{Keyspace: "slow"})
+			slow <- s
+		}()
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Get(Options{Keyspace: "vertice"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		c.Assert(err, check.IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("Get waited for the connection of another session")
+	}
+	close(unlock)
+	s1, s2 := <-slow, <-slow
+	c.Assert(s1, check.NotNil)
+	c.Assert(s2, check.Equals, s1)
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(dials, check.DeepEquals, map[string]int{"slow": 1, "vertice": 1})
+}
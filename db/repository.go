@@ -0,0 +1,415 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Tag naming the column of a field, the field name in lower case when
+	// missing. Fields tagged "-" are not stored.
+	columnTag = "cql"
+
+	// Tag marking the key columns of a model, as "partition" or
+	// "clustering". The keys are ordered as the fields of the struct,
+	// unless given a position, as in "clustering,1": the positioned keys
+	// come first, the others follow in field order. Clustering columns
+	// declared DESC in the CLUSTERING ORDER BY of the table are marked
+	// "desc", as in "clustering,1,desc".
+	keyTag = "cqlkey"
+)
+
+// Tabler is implemented by the models naming their table. The table of the
+// other models is the snake case name of their type.
+type Tabler interface {
+	TableName() string
+}
+
+// Schema is the table of a model, as described by its struct tags:
+//
+//	type EventsObc struct {
+//		EventType string    `json:"event_type" cql:"event_type" cqlkey:"partition"`
+//		CreatedAt time.Time `json:"created_at" cql:"created_at" cqlkey:"partition"`
+//		HostId    string    `json:"host_id" cql:"host_id" cqlkey:"clustering"`
+//		Data      []string  `json:"data" cql:"data"`
+//	}
+type Schema struct {
+	Table      string
+	Partition  []string
+	Clustering []string
+	Columns    []string
+
+	// The clustering columns in descending order.
+	Descending []string
+
+	typ    reflect.Type
+	fields map[string]int
+}
+
+var (
+	schemas   = make(map[reflect.Type]*Schema)
+	schemasMu sync.Mutex
+)
+
+// SchemaOf returns the schema of the given model, a struct or a pointer to
+// one.
+func SchemaOf(model interface{}) (*Schema, error) {
+	typ := reflect.TypeOf(model)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Model %T is not a struct.", model)
+	}
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	if s, ok := schemas[typ]; ok {
+		return s, nil
+	}
+	s := &Schema{
+		Table:  tableOf(typ),
+		typ:    typ,
+		fields: make(map[string]int),
+	}
+	var partition, clustering keyColumns
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		column, ok := columnOf(f)
+		if !ok {
+			continue
+		}
+		s.Columns = append(s.Columns, column)
+		s.fields[column] = i
+		key := f.Tag.Get(keyTag)
+		if key == "" {
+			continue
+		}
+		role, position, desc, ok := parseKey(key)
+		switch {
+		case ok && role == "partition" && !desc:
+			partition = append(partition, keyColumn{column, position})
+		case ok && role == "clustering":
+			clustering = append(clustering, keyColumn{column, position})
+			if desc {
+				s.Descending = append(s.Descending, column)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown key %q on %s.%s.", key, typ.Name(), f.Name)
+		}
+	}
+	s.Partition = partition.columns()
+	s.Clustering = clustering.columns()
+	if len(s.Partition) == 0 {
+		return nil, fmt.Errorf("Model %s has no partition key.", typ.Name())
+	}
+	schemas[typ] = s
+	return s, nil
+}
+
+// parseKey splits a key tag in its role, position, zero when missing, and
+// order.
+func parseKey(key string) (string, int, bool, bool) {
+	parts := strings.Split(key, ",")
+	desc := len(parts) > 1 && parts[len(parts)-1] == "desc"
+	if desc {
+		parts = parts[:len(parts)-1]
+	}
+	switch len(parts) {
+	case 1:
+		return parts[0], 0, desc, true
+	case 2:
+		position, err := strconv.Atoi(parts[1])
+		return parts[0], position, desc, err == nil && position > 0
+	}
+	return "", 0, false, false
+}
+
+type keyColumn struct {
+	column   string
+	position int
+}
+
+// keyColumns sorts the keys by position, keeping the field order of the
+// keys without one, after the others.
+type keyColumns []keyColumn
+
+func (k keyColumns) Len() int      { return len(k) }
+func (k keyColumns) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+
+func (k keyColumns) Less(i, j int) bool {
+	if k[i].position == 0 || k[j].position == 0 {
+		return k[j].position == 0 && k[i].position != 0
+	}
+	return k[i].position < k[j].position
+}
+
+func (k keyColumns) columns() []string {
+	sort.Stable(k)
+	var columns []string
+	for _, c := range k {
+		columns = append(columns, c.column)
+	}
+	return columns
+}
+
+// columnOf returns the column of a field, if it is stored.
+func columnOf(f reflect.StructField) (string, bool) {
+	column := f.Tag.Get(columnTag)
+	if f.PkgPath != "" || column == "-" {
+		return "", false
+	}
+	if column == "" {
+		column = strings.ToLower(f.Name)
+	}
+	return column, true
+}
+
+func tableOf(typ reflect.Type) string {
+	if t, ok := reflect.New(typ).Interface().(Tabler); ok {
+		return t.TableName()
+	}
+	var b []rune
+	for i, r := range typ.Name() {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b = append(b, '_')
+		}
+		b = append(b, r)
+	}
+	return strings.ToLower(string(b))
+}
+
+// IsKey tells whether column is a partition or clustering key.
+func (s *Schema) IsKey(column string) bool {
+	for _, k := range s.Partition {
+		if k == column {
+			return true
+		}
+	}
+	for _, k := range s.Clustering {
+		if k == column {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns the struct of the model held by row, a model or a pointer
+// to one.
+func (s *Schema) value(row interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(row)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type() != s.typ {
+		return reflect.Value{}, fmt.Errorf("Expected a %s, got %T.", s.typ.Name(), row)
+	}
+	return v, nil
+}
+
+func (s *Schema) values(v reflect.Value, columns []string) map[string]interface{} {
+	m := make(map[string]interface{}, len(columns))
+	for _, c := range columns {
+		m[c] = v.Field(s.fields[c]).Interface()
+	}
+	return m
+}
+
+// ListOptions pages the rows of a List.
+type ListOptions struct {
+	Limit  int
+	Offset int
+}
+
+// Repository stores the rows of a model in its table, so that the model
+// is the only description of the table. The connection and query options
+// are the ones of the Options it is created with.
+type Repository struct {
+	Schema *Schema
+	ops    Options
+}
+
+// NewRepository returns the repository of the given model. The table and
+// keys of ops are those of the model.
+func NewRepository(model interface{}, ops Options) (*Repository, error) {
+	s, err := SchemaOf(model)
+	if err != nil {
+		return nil, err
+	}
+	ops.TableName = s.Table
+	ops.Pks = s.Partition
+	ops.Ccms = s.Clustering
+	ops.Descending = s.Descending
+	ops.PksClauses = nil
+	ops.CcmsClauses = nil
+	return &Repository{Schema: s, ops: ops}, nil
+}
+
+// options returns the options addressing the row of the keys of v.
+func (r *Repository) options(v reflect.Value) Options {
+	ops := r.ops
+	ops.PksClauses = r.Schema.values(v, r.Schema.Partition)
+	ops.CcmsClauses = r.Schema.values(v, r.Schema.Clustering)
+	return ops
+}
+
+// Get reads the row with the keys of row into row, a pointer to the model.
+func (r *Repository) Get(ctx context.Context, row interface{}) error {
+	v, err := r.Schema.value(row)
+	if err != nil {
+		return err
+	}
+	if !v.CanSet() {
+		return fmt.Errorf("Expected a pointer to %s, got %T.", r.Schema.typ.Name(), row)
+	}
+	return FetchdbCtx(ctx, r.options(v), row)
+}
+
+// where returns the options of the rows whose columns have the values of
+// where.
+func (r *Repository) where(where map[string]interface{}) (Options, error) {
+	for c := range where {
+		if _, ok := r.Schema.fields[c]; !ok {
+			return Options{}, fmt.Errorf("Unknown column %s of %s.", c, r.Schema.Table)
+		}
+	}
+	ops := r.ops
+	ops.PksClauses = where
+	ops.CcmsClauses = map[string]interface{}{}
+	return ops, nil
+}
+
+// List reads the rows whose columns have the values of where into out, a
+// pointer to a slice of the model. The rows are filtered, so the columns
+// need not be keys.
+func (r *Repository) List(ctx context.Context, where map[string]interface{}, opts ListOptions, out interface{}) error {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice || slice.Elem().Type().Elem() != r.Schema.typ {
+		return fmt.Errorf("Expected a pointer to []%s, got %T.", r.Schema.typ.Name(), out)
+	}
+	ops, err := r.where(where)
+	if err != nil {
+		return err
+	}
+	limit := opts.Limit
+	if limit > 0 {
+		limit += opts.Offset
+	}
+	if err := FetchListdbCtx(ctx, ops, limit, reflect.Zero(r.Schema.typ).Interface(), out); err != nil {
+		return err
+	}
+	if opts.Offset > 0 {
+		rows := slice.Elem()
+		if opts.Offset >= rows.Len() {
+			rows.Set(rows.Slice(0, 0))
+		} else {
+			rows.Set(rows.Slice(opts.Offset, rows.Len()))
+		}
+	}
+	return nil
+}
+
+// Page reads a page of the rows matching where into out, a pointer to a
+// slice of the model, and returns the cursor of the next page, empty after
+// the last one.
+func (r *Repository) Page(ctx context.Context, where map[string]interface{}, page Page, out interface{}) (string, error) {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice || slice.Elem().Type().Elem() != r.Schema.typ {
+		return "", fmt.Errorf("Expected a pointer to []%s, got %T.", r.Schema.typ.Name(), out)
+	}
+	ops, err := r.where(where)
+	if err != nil {
+		return "", err
+	}
+	return FetchPagedb(ctx, ops, page, out)
+}
+
+// Iterate returns an iterator on the rows matching where, read from the
+// given page on.
+func (r *Repository) Iterate(ctx context.Context, where map[string]interface{}, page Page) *RowIterator {
+	ops, err := r.where(where)
+	if err != nil {
+		return &RowIterator{err: err}
+	}
+	return Iteratedb(ctx, ops, page, reflect.Zero(r.Schema.typ).Interface())
+}
+
+// Scan calls fn with a pointer to each row of the table, as Scandb.
+func (r *Repository) Scan(ctx context.Context, ranges []TokenRange, size, workers int, fn func(row interface{}) error) error {
+	return Scandb(ctx, r.ops, reflect.Zero(r.Schema.typ).Interface(), ranges, size, workers, fn)
+}
+
+// Put inserts row, replacing the row with the same keys.
+func (r *Repository) Put(ctx context.Context, row interface{}) error {
+	v, err := r.Schema.value(row)
+	if err != nil {
+		return err
+	}
+	return StoredbCtx(ctx, r.options(v), v.Interface())
+}
+
+// Insert returns the mutation inserting row, to be applied in a Batch.
+func (r *Repository) Insert(row interface{}) Mutation {
+	v, err := r.Schema.value(row)
+	if err != nil {
+		return Mutation{Kind: InsertMutation, Options: r.ops, err: err}
+	}
+	return Insert(r.options(v), v.Interface())
+}
+
+// Update writes the given columns of row, all the columns other than the
+// keys when none is given, to the row with the keys of row.
+func (r *Repository) Update(ctx context.Context, row interface{}, columns ...string) error {
+	v, err := r.Schema.value(row)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		for _, c := range r.Schema.Columns {
+			if !r.Schema.IsKey(c) {
+				columns = append(columns, c)
+			}
+		}
+	}
+	for _, c := range columns {
+		if _, ok := r.Schema.fields[c]; !ok {
+			return fmt.Errorf("Unknown column %s of %s.", c, r.Schema.Table)
+		}
+		if r.Schema.IsKey(c) {
+			return fmt.Errorf("Key %s of %s can't be updated.", c, r.Schema.Table)
+		}
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("No column of %s to update.", r.Schema.Table)
+	}
+	return UpdatedbCtx(ctx, r.options(v), r.Schema.values(v, columns))
+}
+
+// Delete removes the row with the keys of row.
+func (r *Repository) Delete(ctx context.Context, row interface{}) error {
+	v, err := r.Schema.value(row)
+	if err != nil {
+		return err
+	}
+	return DeletedbCtx(ctx, r.options(v), v.Interface())
+}
@@ -0,0 +1,260 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package db
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/megamsys/gocassa"
+	"gopkg.in/check.v1"
+)
+
+type fakeTable struct {
+	gocassa.MultimapMkTable
+	name   string
+	pks    []string
+	ccms   []string
+	call   string
+	fields map[string]interface{}
+	ids    map[string]interface{}
+	data   interface{}
+	limit  int
+	rows   interface{}
+}
+
+func (t *fakeTable) op(call string, fields, ids map[string]interface{}, data interface{}) gocassa.Op {
+	t.call, t.fields, t.ids, t.data = call, fields, ids, data
+	return &fakeOp{fill: func() {
+		if t.rows != nil {
+			reflect.ValueOf(data).Elem().Set(reflect.ValueOf(t.rows))
+		}
+	}}
+}
+
+func (t *fakeTable) Set(v interface{}) gocassa.Op {
+	return t.op("set", nil, nil, v)
+}
+
+func (t *fakeTable) Update(v, id map[string]interface{}, m map[string]interface{}) gocassa.Op {
+	return t.op("update", v, id, m)
+}
+
+func (t *fakeTable) Delete(v, id map[string]interface{}) gocassa.Op {
+	return t.op("delete", v, id, nil)
+}
+
+func (t *fakeTable) List(v, startId map[string]interface{}, limit int, out interface{}) gocassa.Op {
+	t.limit = limit
+	return t.op("list", v, startId, out)
+}
+
+func (t *fakeTable) Read(v, id map[string]interface{}, out interface{}) gocassa.Op {
+	return t.op("read", v, id, out)
+}
+
+func (k *fakeKeySpace) MultimapMultiKeyTable(name string, pks, ccms []string, row interface{}) gocassa.MultimapMkTable {
+	k.table.name, k.table.pks, k.table.ccms = name, pks, ccms
+	return k.table
+}
+
+type testEvent struct {
+	EventType string    `json:"event_type" cql:"event_type" cqlkey:"partition"`
+	CreatedAt time.Time `json:"created_at" cql:"created_at" cqlkey:"partition"`
+	HostId    string    `json:"host_id" cql:"host_id" cqlkey:"clustering"`
+	AccountId string    `json:"account_id" cql:"account_id" cqlkey:"clustering"`
+	Data      []string  `json:"data" cql:"data"`
+	Seen      bool
+	Ignored   string `cql:"-"`
+}
+
+type testNamed struct {
+	Id string `cql:"id" cqlkey:"partition"`
+}
+
+func (testNamed) TableName() string {
+	return "named"
+}
+
+// fakeRepository returns a repository of model on a fake table, and the
+// function restoring DefaultPool.
+func fakeRepository(c *check.C, model interface{}) (*Repository, *fakeTable, func()) {
+	tbl := &fakeTable{}
+	p := NewPool()
+	p.connect = func(opts ScyllaDBOpts) (*ScyllaDB, error) {
+		return &ScyllaDB{NodeIps: opts.NodeIps, KS: &fakeKeySpace{name: opts.KeySpaceName, table: tbl}}, nil
+	}
+	saved := DefaultPool
+	DefaultPool = p
+	r, err := NewRepository(model, Options{Hosts: []string{"10.0.0.1"}, Keyspace: "repository_test"})
+	c.Assert(err, check.IsNil)
+	return r, tbl, func() { DefaultPool = saved }
+}
+
+func (s *S) TestSchemaOf(c *check.C) {
+	schema, err := SchemaOf(&testEvent{})
+	c.Assert(err, check.IsNil)
+	c.Assert(schema.Table, check.Equals, "test_event")
+	c.Assert(schema.Partition, check.DeepEquals, []string{"event_type", "created_at"})
+	c.Assert(schema.Clustering, check.DeepEquals, []string{"host_id", "account_id"})
+	c.Assert(schema.Columns, check.DeepEquals, []string{"event_type", "created_at", "host_id", "account_id", "data", "seen"})
+	c.Assert(schema.IsKey("host_id"), check.Equals, true)
+	c.Assert(schema.IsKey("data"), check.Equals, false)
+	named, err := SchemaOf(testNamed{})
+	c.Assert(err, check.IsNil)
+	c.Assert(named.Table, check.Equals, "named")
+}
+
+func (s *S) TestSchemaOfErrors(c *check.C) {
+	_, err := SchemaOf("events")
+	c.Assert(err, check.ErrorMatches, "Model string is not a struct.")
+	_, err = SchemaOf(struct {
+		Id string `cql:"id"`
+	}{})
+	c.Assert(err, check.ErrorMatches, "Model  has no partition key.")
+	_, err = SchemaOf(struct {
+		Id string `cql:"id" cqlkey:"primary"`
+	}{})
+	c.Assert(err, check.ErrorMatches, `Unknown key "primary" on .Id.`)
+	_, err = SchemaOf(struct {
+		Id string `cql:"id" cqlkey:"partition,first"`
+	}{})
+	c.Assert(err, check.ErrorMatches, `Unknown key "partition,first" on .Id.`)
+}
+
+func (s *S) TestSchemaOfKeyPositions(c *check.C) {
+	schema, err := SchemaOf(struct {
+		Id        string `cql:"id" cqlkey:"partition"`
+		Seen      bool   `cql:"seen" cqlkey:"clustering"`
+		AccountId string `cql:"account_id" cqlkey:"clustering,2"`
+		HostId    string `cql:"host_id" cqlkey:"clustering,1"`
+	}{})
+	c.Assert(err, check.IsNil)
+	c.Assert(schema.Clustering, check.DeepEquals, []string{"host_id", "account_id", "seen"})
+	c.Assert(schema.Columns, check.DeepEquals, []string{"id", "seen", "account_id", "host_id"})
+	c.Assert(schema.Descending, check.HasLen, 0)
+}
+
+func (s *S) TestSchemaOfDescending(c *check.C) {
+	schema, err := SchemaOf(struct {
+		AccountId string    `cql:"account_id" cqlkey:"partition"`
+		Id        string    `cql:"id" cqlkey:"clustering,2"`
+		CreatedAt time.Time `cql:"created_at" cqlkey:"clustering,1,desc"`
+	}{})
+	c.Assert(err, check.IsNil)
+	c.Assert(schema.Clustering, check.DeepEquals, []string{"created_at", "id"})
+	c.Assert(schema.Descending, check.DeepEquals, []string{"created_at"})
+	_, err = SchemaOf(struct {
+		Id string `cql:"id" cqlkey:"partition,desc"`
+	}{})
+	c.Assert(err, check.ErrorMatches, `Unknown key "partition,desc" on .Id.`)
+	_, err = SchemaOf(struct {
+		Id   string `cql:"id" cqlkey:"partition"`
+		Seen bool   `cql:"seen" cqlkey:"clustering,1,desc,2"`
+	}{})
+	c.Assert(err, check.ErrorMatches, `Unknown key "clustering,1,desc,2" on .Seen.`)
+}
+
+func (s *S) TestRepositoryPut(c *check.C) {
+	r, tbl, restore := fakeRepository(c, testEvent{})
+	defer restore()
+	e := testEvent{EventType: "compute.instance.launched", HostId: "h1", AccountId: "info@megam.io", Data: []string{"up"}}
+	c.Assert(r.Put(context.Background(), &e), check.IsNil)
+	c.Assert(tbl.name, check.Equals, "test_event")
+	c.Assert(tbl.pks, check.DeepEquals, []string{"event_type", "created_at"})
+	c.Assert(tbl.ccms, check.DeepEquals, []string{"host_id", "account_id"})
+	c.Assert(tbl.call, check.Equals, "set")
+	c.Assert(tbl.data, check.DeepEquals, e)
+	err := r.Put(context.Background(), testNamed{Id: "1"})
+	c.Assert(err, check.ErrorMatches, "Expected a testEvent, got db.testNamed.")
+}
+
+func (s *S) TestRepositoryGet(c *check.C) {
+	r, tbl, restore := fakeRepository(c, testEvent{})
+	defer restore()
+	e := testEvent{EventType: "compute.instance.launched", HostId: "h1", AccountId: "info@megam.io"}
+	c.Assert(r.Get(context.Background(), &e), check.IsNil)
+	c.Assert(tbl.call, check.Equals, "read")
+	c.Assert(tbl.fields, check.DeepEquals, map[string]interface{}{"event_type": "compute.instance.launched", "created_at": time.Time{}})
+	c.Assert(tbl.ids, check.DeepEquals, map[string]interface{}{"host_id": "h1", "account_id": "info@megam.io"})
+	c.Assert(tbl.data, check.Equals, &e)
+	err := r.Get(context.Background(), e)
+	c.Assert(err, check.ErrorMatches, `Expected a pointer to testEvent, got db.testEvent.`)
+}
+
+func (s *S) TestRepositoryList(c *check.C) {
+	r, tbl, restore := fakeRepository(c, testEvent{})
+	defer restore()
+	tbl.rows = []testEvent{{HostId: "h1"}, {HostId: "h2"}, {HostId: "h3"}}
+	var events []testEvent
+	where := map[string]interface{}{"account_id": "info@megam.io"}
+	c.Assert(r.List(context.Background(), where, ListOptions{Limit: 2, Offset: 1}, &events), check.IsNil)
+	c.Assert(tbl.call, check.Equals, "list")
+	c.Assert(tbl.fields, check.DeepEquals, where)
+	c.Assert(tbl.limit, check.Equals, 3)
+	c.Assert(events, check.DeepEquals, []testEvent{{HostId: "h2"}, {HostId: "h3"}})
+	c.Assert(r.List(context.Background(), where, ListOptions{Offset: 5}, &events), check.IsNil)
+	c.Assert(tbl.limit, check.Equals, 0)
+	c.Assert(events, check.HasLen, 0)
+	err := r.List(context.Background(), map[string]interface{}{"email": "info@megam.io"}, ListOptions{}, &events)
+	c.Assert(err, check.ErrorMatches, "Unknown column email of test_event.")
+	err = r.List(context.Background(), where, ListOptions{}, events)
+	c.Assert(err, check.ErrorMatches, `Expected a pointer to \[\]testEvent, got \[\]db.testEvent.`)
+}
+
+func (s *S) TestRepositoryUpdate(c *check.C) {
+	r, tbl, restore := fakeRepository(c, testEvent{})
+	defer restore()
+	e := testEvent{EventType: "compute.instance.launched", HostId: "h1", AccountId: "info@megam.io", Data: []string{"up"}, Seen: true}
+	c.Assert(r.Update(context.Background(), e, "seen"), check.IsNil)
+	c.Assert(tbl.call, check.Equals, "update")
+	c.Assert(tbl.ids, check.DeepEquals, map[string]interface{}{"host_id": "h1", "account_id": "info@megam.io"})
+	c.Assert(tbl.data, check.DeepEquals, map[string]interface{}{"seen": true})
+	c.Assert(r.Update(context.Background(), e), check.IsNil)
+	c.Assert(tbl.data, check.DeepEquals, map[string]interface{}{"data": []string{"up"}, "seen": true})
+	err := r.Update(context.Background(), e, "host_id")
+	c.Assert(err, check.ErrorMatches, "Key host_id of test_event can't be updated.")
+	err = r.Update(context.Background(), e, "email")
+	c.Assert(err, check.ErrorMatches, "Unknown column email of test_event.")
+}
+
+func (s *S) TestRepositoryDelete(c *check.C) {
+	r, tbl, restore := fakeRepository(c, testEvent{})
+	defer restore()
+	e := testEvent{EventType: "compute.instance.launched", HostId: "h1", AccountId: "info@megam.io"}
+	c.Assert(r.Delete(context.Background(), &e), check.IsNil)
+	c.Assert(tbl.call, check.Equals, "delete")
+	c.Assert(tbl.fields, check.DeepEquals, map[string]interface{}{"event_type": "compute.instance.launched", "created_at": time.Time{}})
+	c.Assert(tbl.ids, check.DeepEquals, map[string]interface{}{"host_id": "h1", "account_id": "info@megam.io"})
+}
+
+func (s *S) TestRepositoryInsert(c *check.C) {
+	m := NewMemoryBackend()
+	defer SetBackend(SetBackend(m))
+	r, err := NewRepository(testEvent{}, Options{Keyspace: "memory_test"})
+	c.Assert(err, check.IsNil)
+	e := testEvent{EventType: "compute.instance.launched", HostId: "h1", AccountId: "info@megam.io"}
+	applied, err := NewBatch(LoggedBatch, r.Insert(&e)).Exec(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Assert(applied, check.Equals, true)
+	rows := m.Rows("memory_test", "test_event")
+	c.Assert(rows, check.HasLen, 1)
+	c.Assert(rows[0]["host_id"], check.Equals, "h1")
+	_, err = NewBatch(LoggedBatch, r.Insert(testNamed{Id: "1"})).Exec(context.Background())
+	c.Assert(err, check.ErrorMatches, "Expected a testEvent, got db.testNamed.")
+}
@@ -1,7 +1,11 @@
 package db
 
 import (
+	"context"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
+	"github.com/megamsys/gocql"
 	"github.com/megamsys/libgo/cmd"
 )
 
@@ -15,21 +19,25 @@ type Options struct {
 	Hosts     []string
 	PksClauses   map[string]interface{}
 	CcmsClauses  map[string]interface{}
+
+	// The Ccms in descending order, as declared by the CLUSTERING ORDER BY
+	// of the table.
+	Descending []string
+
+	// Query options, see Defaults for the ones of a keyspace.
+	Consistency *gocql.Consistency
+	Timeout     time.Duration
+	Retries     *int
+	TTL         time.Duration
+
+	// Marks the writes as safe to apply more than once, so they are
+	// retried like reads.
+	Idempotent bool
 }
 
 //A global function which helps to avoid passing config of riak everywhere.
 func newDBConn(ops Options) (*ScyllaDB, error) {
-	r, err := newScyllaDB(ScyllaDBOpts{
-		KeySpaceName: ops.Keyspace,
-		NodeIps:      ops.Hosts,
-		Username:     ops.Username,
-		Password:     ops.Password,
-		Debug:        true,
-	})
-	if err != nil {
-		return nil, err
-	}
-	return r, nil
+	return DefaultPool.Get(ops)
 }
 
 func (t *ScyllaDB) newScyllaTable(ops Options, data interface{}) *ScyllaTable {
@@ -39,16 +47,59 @@ func (t *ScyllaDB) newScyllaTable(ops Options, data interface{}) *ScyllaTable {
 }
 
 func Fetchdb(tinfo Options, data interface{}) error {
+	return FetchdbCtx(context.Background(), tinfo, data)
+}
+
+func FetchdbCtx(ctx context.Context, tinfo Options, data interface{}) error {
+	return CurrentBackend().Fetch(ctx, tinfo, data)
+}
+
+// dat referes the structure of table and data for array of row
+func FetchListdb(tinfo Options, limit int,dat, data interface{}) error {
+	return FetchListdbCtx(context.Background(), tinfo, limit, dat, data)
+}
+
+func FetchListdbCtx(ctx context.Context, tinfo Options, limit int, dat, data interface{}) error {
+	return CurrentBackend().FetchList(ctx, tinfo, limit, dat, data)
+}
+
+func Storedb(tinfo Options, data interface{}) error {
+	return StoredbCtx(context.Background(), tinfo, data)
+}
+
+func StoredbCtx(ctx context.Context, tinfo Options, data interface{}) error {
+	return CurrentBackend().Store(ctx, tinfo, data)
+}
+
+func Updatedb(tinfo Options, data map[string]interface{}) error {
+	return UpdatedbCtx(context.Background(), tinfo, data)
+}
+
+func UpdatedbCtx(ctx context.Context, tinfo Options, data map[string]interface{}) error {
+	return CurrentBackend().Update(ctx, tinfo, data)
+}
+
+func Deletedb(tinfo Options, data interface{}) error {
+	return DeletedbCtx(context.Background(), tinfo, data)
+}
+
+func DeletedbCtx(ctx context.Context, tinfo Options, data interface{}) error {
+	return CurrentBackend().Delete(ctx, tinfo, data)
+}
+
+// scyllaBackend is the Backend of a Scylla cluster, through DefaultPool.
+type scyllaBackend struct{}
+
+func (scyllaBackend) Fetch(ctx context.Context, tinfo Options, data interface{}) error {
 	t, err := newDBConn(tinfo)
 	if err != nil {
 		return err
 	}
-	defer t.Close()
 
 	d := t.newScyllaTable(tinfo, data)
 	if d != nil {
 		//err := d.read(ScyllaWhere{Clauses: tinfo.Clauses}, data)
-		err = d.read(tinfo.PksClauses, tinfo.CcmsClauses, data)
+		err = run(ctx, tinfo, d.read(tinfo.PksClauses, tinfo.CcmsClauses, data), true)
 		if err != nil {
 			return err
 		}
@@ -56,17 +107,15 @@ func Fetchdb(tinfo Options, data interface{}) error {
 	return nil
 }
 
-// dat referes the structure of table and data for array of row
-func FetchListdb(tinfo Options, limit int,dat, data interface{}) error {
+func (scyllaBackend) FetchList(ctx context.Context, tinfo Options, limit int, dat, data interface{}) error {
 	t, err := newDBConn(tinfo)
 	if err != nil {
 		return err
 	}
-	defer t.Close()
 	d := t.newScyllaTable(tinfo, dat)
 	if d != nil {
 		//err := d.read(ScyllaWhere{Clauses: tinfo.Clauses}, data)
-		err = d.readMulti(tinfo.PksClauses, limit, data)
+		err = run(ctx, tinfo, d.readMulti(tinfo.PksClauses, limit, data), true)
 		if err != nil {
 			return err
 		}
@@ -74,43 +123,39 @@ func FetchListdb(tinfo Options, limit int,dat, data interface{}) error {
 	return nil
 }
 
-
-func Storedb(tinfo Options, data interface{}) error {
+func (scyllaBackend) Store(ctx context.Context, tinfo Options, data interface{}) error {
 	c, err := newDBConn(tinfo)
 	if err != nil {
 		return err
 	}
-	defer c.Close()
 	t := c.newScyllaTable(tinfo, data)
-	err = t.insert(data)
+	err = run(ctx, tinfo, t.insert(data), tinfo.Idempotent)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func Updatedb(tinfo Options, data map[string]interface{}) error {
+func (scyllaBackend) Update(ctx context.Context, tinfo Options, data map[string]interface{}) error {
 	c, err := newDBConn(tinfo)
 	if err != nil {
 		return err
 	}
-	defer c.Close()
 	t := c.newScyllaTable(tinfo, data)
-	err = t.update(tinfo, data)
+	err = run(ctx, tinfo, t.update(tinfo, data), tinfo.Idempotent)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func Deletedb(tinfo Options, data interface{}) error {
+func (scyllaBackend) Delete(ctx context.Context, tinfo Options, data interface{}) error {
 	c, err := newDBConn(tinfo)
 	if err != nil {
 		return err
 	}
-	defer c.Close()
 	t := c.newScyllaTable(tinfo, data)
-	err = t.deleterow(tinfo)
+	err = run(ctx, tinfo, t.deleterow(tinfo), tinfo.Idempotent)
 	if err != nil {
 		return err
 	}
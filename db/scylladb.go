@@ -1,8 +1,11 @@
 package db
 
 import (
+	"sync"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/megamsys/gocassa"
+	"github.com/megamsys/gocql"
 	"github.com/megamsys/libgo/cmd"
 )
 
@@ -11,6 +14,13 @@ type RelationsFunc func() gocassa.Relation
 type ScyllaDB struct {
 	NodeIps []string
 	KS      gocassa.KeySpace
+
+	// The session of the statements gocassa can't build, opened on first
+	// use. The pinned gocassa can't be built on an existing gocql session,
+	// so it is not the session of KS.
+	opts    ScyllaDBOpts
+	mu      sync.Mutex
+	session *gocql.Session
 }
 
 type ScyllaTable struct {
@@ -39,6 +49,7 @@ func newScyllaDB(opts ScyllaDBOpts) (*ScyllaDB, error) {
 	return &ScyllaDB{
 		NodeIps: opts.NodeIps,
 		KS:      ks,
+		opts:    opts,
 	}, nil
 }
 
@@ -57,34 +68,60 @@ func (sy *ScyllaDB) table(name string, pks []string, ccms []string, out interfac
 	return &ScyllaTable{T: sy.KS.MultimapMultiKeyTable(name, pks, ccms, out)}
 }
 
+// Session returns the gocql session of the keyspace.
+func (sy *ScyllaDB) Session() (*gocql.Session, error) {
+	sy.mu.Lock()
+	defer sy.mu.Unlock()
+	if sy.session != nil {
+		return sy.session, nil
+	}
+	cluster := gocql.NewCluster(sy.NodeIps...)
+	cluster.Keyspace = sy.opts.KeySpaceName
+	if sy.opts.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: sy.opts.Username, Password: sy.opts.Password}
+	}
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	sy.session = session
+	return session, nil
+}
+
 func (sy *ScyllaDB) Close() {
 	log.Debugf(cmd.Colorfy("  > [scylla] Connection close", "blue", "", "bold"))
 	sy.KS.Close()
+	sy.mu.Lock()
+	if sy.session != nil {
+		sy.session.Close()
+		sy.session = nil
+	}
+	sy.mu.Unlock()
 }
 
-func (st *ScyllaTable) read(fields, ids map[string]interface{}, out interface{}) error {
+func (st *ScyllaTable) read(fields, ids map[string]interface{}, out interface{}) gocassa.Op {
 	log.Debugf(cmd.Colorfy("  > [scylla] read", "blue", "", "bold"))
 	op := gocassa.Options{AllowFiltering: true}
-	return st.T.Read(fields, ids, out).WithOptions(op).Run()
+	return st.T.Read(fields, ids, out).WithOptions(op)
 }
 
-func (st *ScyllaTable) readMulti(fields map[string]interface{},limit int,out interface{}) error {
+func (st *ScyllaTable) readMulti(fields map[string]interface{},limit int,out interface{}) gocassa.Op {
 	log.Debugf(cmd.Colorfy("  > [scylla] read", "blue", "", "bold"))
 	op := gocassa.Options{AllowFiltering: true}
-	return st.T.List(fields, nil, limit,out).WithOptions(op).Run()
+	return st.T.List(fields, nil, limit,out).WithOptions(op)
 }
 
-func (st *ScyllaTable) insert(data interface{}) error {
+func (st *ScyllaTable) insert(data interface{}) gocassa.Op {
 	log.Debugf(cmd.Colorfy("  > [scylla] insert", "blue", "", "bold"))
-	return st.T.Set(data).Run()
+	return st.T.Set(data)
 }
 
-func (st *ScyllaTable) update(tinfo Options, data map[string]interface{}) error {
+func (st *ScyllaTable) update(tinfo Options, data map[string]interface{}) gocassa.Op {
 	log.Debugf(cmd.Colorfy("  > [scylla] update", "blue", "", "bold"))
-	return st.T.Update(tinfo.PksClauses, tinfo.CcmsClauses, data).Run()
+	return st.T.Update(tinfo.PksClauses, tinfo.CcmsClauses, data)
 }
 
-func (st *ScyllaTable) deleterow(tinfo Options) error {
+func (st *ScyllaTable) deleterow(tinfo Options) gocassa.Op {
 	log.Debugf(cmd.Colorfy("  > [scylla] delete", "blue", "", "bold"))
-	return st.T.Delete(tinfo.PksClauses, tinfo.CcmsClauses).Run()
+	return st.T.Delete(tinfo.PksClauses, tinfo.CcmsClauses)
 }
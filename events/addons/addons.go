@@ -4,7 +4,6 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/megamsys/libgo/api"
 	constants "github.com/megamsys/libgo/utils"
-	"encoding/json"
 	"github.com/megamsys/libgo/events/alerts"
 	"fmt"
 )
@@ -17,10 +16,10 @@ const (
 	)
 
 type Addons struct {
-	Id           string   `json:"id" cql:"id"`
-	ProviderName string   `json:"provider_name" cql:"provider_name"`
+	Id           string   `json:"id" cql:"id" cqlkey:"clustering,2"`
+	ProviderName string   `json:"provider_name" cql:"provider_name" cqlkey:"clustering,1"`
 	ProviderId   string   `json:"provider_id" cql:"provider_id"`
-	AccountId    string   `json:"account_id" cql:"account_id"`
+	AccountId    string   `json:"account_id" cql:"account_id" cqlkey:"partition"`
 	Options      []string `json:"options" cql:"options"`
 }
 
@@ -56,18 +55,5 @@ func (s *Addons) Get(m map[string]string) error {
 	if s.AccountId == "" {
 	 return fmt.Errorf("account_id should not be empty")
 	}
-	args := api.NewArgs(m)
-	cl := api.NewClient(args, GETADDONS + s.ProviderName)
-	response, err := cl.Get()
-	if err != nil {
-		return err
-	}
-
-	o := &ApiAddons{}
-	err = json.Unmarshal(response, o)
-	if err != nil {
-		return err
-	}
-	s = &o.Results[0]
-	return nil
+	return api.NewGateway(api.NewArgs(m)).Addons.Get(s.ProviderName, s)
 }
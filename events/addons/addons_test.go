@@ -0,0 +1,38 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package addons
+
+import (
+	"testing"
+
+	ldb "github.com/megamsys/libgo/db"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func (s *S) TestAddonsRepository(c *check.C) {
+	r, err := ldb.NewRepository(Addons{}, ldb.Options{Keyspace: "vertice"})
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Schema.Table, check.Equals, "addons")
+	c.Assert(r.Schema.Partition, check.DeepEquals, []string{"account_id"})
+	c.Assert(r.Schema.Clustering, check.DeepEquals, []string{"provider_name", "id"})
+}
@@ -1,6 +1,7 @@
 package alerts
 
 import (
+	"context"
 	log "github.com/Sirupsen/logrus"
 	ldb "github.com/megamsys/libgo/db"
 	constants "github.com/megamsys/libgo/utils/obc"
@@ -8,81 +9,163 @@ import (
 	"time"
 )
 
-const EVENTSOBCBUCKET = "events_for_obc"
+const (
+	EVENTSOBCBUCKET          = "events_for_obc"
+	EVENTSOBCBYACCOUNTBUCKET = "events_for_obc_by_account"
+)
 
 type ObcEvents *[]EventsObc
 
 type EventsObc struct {
 	Id        string    `json:"id" cql:"id"`
+	EventType string    `json:"event_type" cql:"event_type" cqlkey:"partition"`
+	AccountId string    `json:"account_id" cql:"account_id" cqlkey:"clustering,2"`
+	HostIp    string    `json:"host_ip" cql:"host_ip"`
+	HostId    string    `json:"host_id" cql:"host_id" cqlkey:"clustering,1"`
+	Data      []string  `json:"data" cql:"data"`
+	CreatedAt time.Time `json:"created_at" cql:"created_at" cqlkey:"partition"`
+}
+
+func (EventsObc) TableName() string {
+	return EVENTSOBCBUCKET
+}
+
+// EventsObcByAccount are the EventsObc keyed by account, newest first, to
+// list the events of an account without filtering.
+type EventsObcByAccount struct {
+	AccountId string    `json:"account_id" cql:"account_id" cqlkey:"partition"`
+	CreatedAt time.Time `json:"created_at" cql:"created_at" cqlkey:"clustering,1,desc"`
+	Id        string    `json:"id" cql:"id" cqlkey:"clustering,2"`
 	EventType string    `json:"event_type" cql:"event_type"`
-	AccountId string    `json:"account_id" cql:"account_id"`
 	HostIp    string    `json:"host_ip" cql:"host_ip"`
 	HostId    string    `json:"host_id" cql:"host_id"`
 	Data      []string  `json:"data" cql:"data"`
-	CreatedAt time.Time `json:"created_at" cql:"created_at"`
 }
 
+func (EventsObcByAccount) TableName() string {
+	return EVENTSOBCBYACCOUNTBUCKET
+}
+
+func (e EventsObc) byAccount() EventsObcByAccount {
+	return EventsObcByAccount{
+		AccountId: e.AccountId,
+		CreatedAt: e.CreatedAt,
+		Id:        e.Id,
+		EventType: e.EventType,
+		HostIp:    e.HostIp,
+		HostId:    e.HostId,
+		Data:      e.Data,
+	}
+}
+
+func (e EventsObcByAccount) event() EventsObc {
+	return EventsObc{
+		Id:        e.Id,
+		EventType: e.EventType,
+		AccountId: e.AccountId,
+		HostIp:    e.HostIp,
+		HostId:    e.HostId,
+		Data:      e.Data,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func (s *Scylla) events() (*ldb.Repository, error) {
+	return s.repository(EventsObc{})
+}
+
+func (s *Scylla) eventsByAccount() (*ldb.Repository, error) {
+	return s.repository(EventsObcByAccount{})
+}
+
+func (s *Scylla) repository(model interface{}) (*ldb.Repository, error) {
+	return ldb.NewRepository(model, ldb.Options{
+		Hosts:    s.Scylla_host,
+		Keyspace: s.Scylla_keyspace,
+		Username: s.Scylla_username,
+		Password: s.Scylla_password,
+		TTL:      s.Scylla_events_ttl,
+	})
+}
+
+// NotifyOBC stores the event in events_for_obc and events_for_obc_by_account
+// together, so both tables, created by the migrations 0001 and 0003, are
+// required.
 func (s *Scylla) NotifyOBC(eva EventAction, edata EventData) error {
 	if !s.satisfied(eva) {
 		return nil
 	}
-	s_data := parseMapToOutputObc(edata)
-	ops := ldb.Options{
-		TableName:   EVENTSOBCBUCKET,
-		Pks:         []string{constants.EVENT_TYPE, constants.CREATED_AT},
-		Ccms:        []string{constants.HOST_ID, constants.ACCOUNT_ID},
-		Hosts:       s.Scylla_host,
-		Keyspace:    s.Scylla_keyspace,
-		Username:    s.Scylla_username,
-		Password:    s.Scylla_password,
-		PksClauses:  map[string]interface{}{constants.EVENT_TYPE: edata.M[constants.EVENT_TYPE], constants.CREATED_AT: s_data.CreatedAt},
-		CcmsClauses: map[string]interface{}{constants.HOST_ID: edata.M[constants.HOST_ID], constants.ACCOUNT_ID: edata.M[constants.ACCOUNT_ID]},
+	r, err := s.events()
+	if err != nil {
+		return err
+	}
+	byAccount, err := s.eventsByAccount()
+	if err != nil {
+		return err
 	}
-	if err := ldb.Storedb(ops, s_data); err != nil {
+	e := parseMapToOutputObc(edata)
+	b := ldb.NewBatch(ldb.LoggedBatch, r.Insert(e), byAccount.Insert(e.byAccount()))
+	if _, err := b.Exec(context.Background()); err != nil {
 		log.Debugf(err.Error())
 		return err
 	}
 	return nil
 }
 
+// GetEventsByEmail returns the events of an account, newest first, all of
+// them when limit is not positive.
 func (s *Scylla) GetEventsByEmail(email string, limit int) (*[]EventsObc, error) {
-	events := &[]EventsObc{}
-	e := EventsObc{}
-
-	ops := ldb.Options{
-		TableName:   EVENTSOBCBUCKET,
-		Pks:         []string{constants.ACCOUNT_ID},
-		Ccms:        []string{},
-		Hosts:       s.Scylla_host,
-		Keyspace:    s.Scylla_keyspace,
-		Username:    s.Scylla_username,
-		Password:    s.Scylla_password,
-		PksClauses:  map[string]interface{}{constants.ACCOUNT_ID: email},
-		CcmsClauses: map[string]interface{}{},
+	events := []EventsObc{}
+	cursor := ""
+	for {
+		page, next, err := s.GetEventsPageByEmail(email, limit, cursor)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *page...)
+		if next == "" || (limit > 0 && len(events) >= limit) {
+			break
+		}
+		cursor = next
 	}
-	if err := ldb.FetchListdb(ops, limit, e, events); err != nil {
-		log.Debugf(err.Error())
-		return nil, err
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
 	}
-	return events, nil
+	return &events, nil
 }
 
 func (s *Scylla) GetEventsByNodeId(email, id string, limit int) (*[]EventsObc, error) {
-	events := &[]EventsObc{}
-	e := EventsObc{}
-
-	ops := ldb.Options{
-		TableName:   EVENTSOBCBUCKET,
-		Pks:         []string{constants.HOST_ID},
-		Ccms:        []string{constants.ACCOUNT_ID},
-		Hosts:       s.Scylla_host,
-		Keyspace:    s.Scylla_keyspace,
-		Username:    s.Scylla_username,
-		Password:    s.Scylla_password,
-		PksClauses:  map[string]interface{}{constants.HOST_ID: id},
-		CcmsClauses: map[string]interface{}{constants.ACCOUNT_ID: email},
+	return s.listEvents(map[string]interface{}{constants.HOST_ID: id, constants.ACCOUNT_ID: email}, limit)
+}
+
+// GetEventsPageByEmail returns a page of the events of an account, newest
+// first, and the cursor of the next page, empty after the last one.
+func (s *Scylla) GetEventsPageByEmail(email string, size int, cursor string) (*[]EventsObc, string, error) {
+	r, err := s.eventsByAccount()
+	if err != nil {
+		return nil, "", err
+	}
+	var rows []EventsObcByAccount
+	page := ldb.Page{Size: size, Cursor: cursor}
+	next, err := r.Page(context.Background(), map[string]interface{}{constants.ACCOUNT_ID: email}, page, &rows)
+	if err != nil {
+		log.Debugf(err.Error())
+		return nil, "", err
+	}
+	events := make([]EventsObc, len(rows))
+	for i, row := range rows {
+		events[i] = row.event()
 	}
-	if err := ldb.FetchListdb(ops, limit, e, events); err != nil {
+	return &events, next, nil
+}
+
+func (s *Scylla) listEvents(where map[string]interface{}, limit int) (*[]EventsObc, error) {
+	r, err := s.events()
+	if err != nil {
+		return nil, err
+	}
+	events := &[]EventsObc{}
+	if err := r.List(context.Background(), where, ldb.ListOptions{Limit: limit}, events); err != nil {
 		log.Debugf(err.Error())
 		return nil, err
 	}
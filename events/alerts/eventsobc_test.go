@@ -0,0 +1,68 @@
+/*
+** Copyright [2013-2016] [Megam Systems]
+**
+** Licensed under the Apache License, Version 2.0 (the "License");
+** you may not use this file except in compliance with the License.
+** You may obtain a copy of the License at
+**
+** http://www.apache.org/licenses/LICENSE-2.0
+**
+** Unless required by applicable law or agreed to in writing, software
+** distributed under the License is distributed on an "AS IS" BASIS,
+** WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+** See the License for the specific language governing permissions and
+** limitations under the License.
+ */
+
+package alerts
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	ldb "github.com/megamsys/libgo/db"
+	"gopkg.in/check.v1"
+)
+
+var primaryKey = regexp.MustCompile(`PRIMARY KEY \(\(([^)]*)\),([^)]*)\)`)
+
+func keyColumns(list string) []string {
+	columns := strings.Split(list, ",")
+	for i, c := range columns {
+		columns[i] = strings.TrimSpace(c)
+	}
+	return columns
+}
+
+func (s *S) TestEventsObcSchemaMatchesMigration(c *check.C) {
+	schema, err := ldb.SchemaOf(EventsObc{})
+	c.Assert(err, check.IsNil)
+	c.Assert(schema.Table, check.Equals, EVENTSOBCBUCKET)
+	cql, err := ioutil.ReadFile("../../db/migrations/0001_create_events_for_obc.up.cql")
+	c.Assert(err, check.IsNil)
+	key := primaryKey.FindStringSubmatch(string(cql))
+	c.Assert(key, check.HasLen, 3)
+	c.Assert(schema.Partition, check.DeepEquals, keyColumns(key[1]))
+	c.Assert(schema.Clustering, check.DeepEquals, keyColumns(key[2]))
+	c.Assert(schema.Clustering, check.DeepEquals, []string{"host_id", "account_id"})
+}
+
+func (s *S) TestEventsObcByAccountSchemaMatchesMigration(c *check.C) {
+	schema, err := ldb.SchemaOf(EventsObcByAccount{})
+	c.Assert(err, check.IsNil)
+	c.Assert(schema.Table, check.Equals, EVENTSOBCBYACCOUNTBUCKET)
+	cql, err := ioutil.ReadFile("../../db/migrations/0003_create_events_for_obc_by_account.up.cql")
+	c.Assert(err, check.IsNil)
+	key := primaryKey.FindStringSubmatch(string(cql))
+	c.Assert(key, check.HasLen, 3)
+	c.Assert(schema.Partition, check.DeepEquals, keyColumns(key[1]))
+	c.Assert(schema.Clustering, check.DeepEquals, keyColumns(key[2]))
+	c.Assert(string(cql), check.Matches, `(?s).*CLUSTERING ORDER BY \(created_at DESC, id ASC\).*`)
+	c.Assert(schema.Descending, check.DeepEquals, []string{"created_at"})
+}
+
+func (s *S) TestEventsObcByAccountRoundTrip(c *check.C) {
+	e := EventsObc{Id: "1", EventType: "obc.launched", AccountId: "info@megam.io", HostIp: "10.0.0.1", HostId: "h1", Data: []string{"a"}}
+	c.Assert(e.byAccount().event(), check.DeepEquals, e)
+}
@@ -1,8 +1,10 @@
 package alerts
 
 import (
+	log "github.com/Sirupsen/logrus"
 	constants "github.com/megamsys/libgo/utils"
 	"strings"
+	"time"
 )
 
 type Scylla struct {
@@ -10,14 +12,24 @@ type Scylla struct {
 	Scylla_keyspace string
 	Scylla_username string
 	Scylla_password string
+	// Expiration of the stored events, none when zero.
+	Scylla_events_ttl time.Duration
 }
 
 func NewScylla(m map[string]string) Notifier {
+	var ttl time.Duration
+	if v := m[constants.SCYLLAEVENTTTL]; v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil {
+			log.Errorf("Invalid %s %q, events will not expire: %s", constants.SCYLLAEVENTTTL, v, err)
+		}
+	}
 	return &Scylla{
-		Scylla_host:     strings.Split(m[constants.SCYLLAHOST], ","),
-		Scylla_keyspace: m[constants.SCYLLAKEYSPACE],
-		Scylla_username: m[constants.SCYLLAUSERNAME],
-		Scylla_password: m[constants.SCYLLAPASSWORD],
+		Scylla_host:       strings.Split(m[constants.SCYLLAHOST], ","),
+		Scylla_keyspace:   m[constants.SCYLLAKEYSPACE],
+		Scylla_username:   m[constants.SCYLLAUSERNAME],
+		Scylla_password:   m[constants.SCYLLAPASSWORD],
+		Scylla_events_ttl: ttl,
 	}
 }
 
@@ -16,7 +16,6 @@
 package bills
 
 import (
-	"encoding/json"
 	"github.com/megamsys/libgo/api"
 )
 
@@ -103,36 +102,22 @@ type States struct {
 
 func NewAccounts(m map[string]string) (*Accounts, error) {
 	args := api.NewArgs(m)
-	cl := api.NewClient(args, ACCOUNTS_GET + args.Email)
-	response, err := cl.Get()
-	if err != nil {
+	a := &Accounts{}
+	if err := api.NewGateway(args).Accounts.Get(args.Email, a); err != nil {
 		return nil, err
 	}
-
-	ac := &ApiAccounts{}
-	err = json.Unmarshal(response, ac)
-	if err != nil {
-		return nil, err
-	}
-	a := &ac.Results
 	return a, nil
 }
 
 func AccountsOrg(email string, m map[string]string) (*Organization, error) {
-	args := api.NewArgs(m)
-	cl := api.NewClient(args, ORGANIZATIONGET)
-	response, err := cl.Get()
-	if err != nil {
+	var orgs []Organization
+	if err := api.NewGateway(api.NewArgs(m)).Organizations.List(nil, &orgs); err != nil {
 		return nil, err
 	}
-
-	o := &ApiOrganizations{}
-	err = json.Unmarshal(response, o)
-	if err != nil {
-		return nil, err
+	if len(orgs) == 0 {
+		return nil, &api.NotFoundError{Path: ORGANIZATIONGET}
 	}
-	org := &o.Results
-	return org, nil
+	return &orgs[0], nil
 }
 
 // func (a *Accounts) convertBillAccount() (*BillAccounts, error) {
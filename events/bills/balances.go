@@ -16,7 +16,6 @@
 package bills
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/megamsys/libgo/api"
 	"gopkg.in/yaml.v2"
@@ -74,20 +73,10 @@ func NewBalances(email string, m map[string]string) (*Balances, error) {
 		return nil, fmt.Errorf("account_id should not be empty")
 	}
 
-	args := api.NewArgs(m)
-	cl := api.NewClient(args, BALANCE + email)
-	response, err := cl.Get()
-	if err != nil {
+	b := &Balances{}
+	if err := api.NewGateway(api.NewArgs(m)).Balances.Get(email, b); err != nil {
 		return nil, err
 	}
-
-	ac := &ApiBalances{}
-	err = json.Unmarshal(response, ac)
-	if err != nil {
-		return nil, err
-	}
-	b := &ac.Results[0]
-
 	return b, nil
 }
 
@@ -25,6 +25,7 @@ import (
 const (
 	NEWBILLEDHISTORY = "/billedhistories/content"
 	BILLJSONCLAZ   = "Megam::Billedhistories"
+	BILLEDHISTORIESBUCKET = "billedhistories"
 )
 
 type BilledHistoriesOpts struct {
@@ -35,15 +36,19 @@ type BilledHistoriesOpts struct {
 }
 
 type BilledHistories struct {
-	AccountId     string    `json:"-" cql:"account_id"`
-	AssemblyId    string    `json:"assembly_id" cql:"assembly_id"`
+	AccountId     string    `json:"-" cql:"account_id" cqlkey:"partition"`
+	AssemblyId    string    `json:"assembly_id" cql:"assembly_id" cqlkey:"clustering,1"`
 	BillType      string    `json:"bill_type" cql:"bill_type"`
 	BillingAmount string    `json:"billing_amount" cql:"billing_amount"`
-	StateDate     string `json:"start_date" cql:"start_date"`
+	StateDate     string `json:"start_date" cql:"start_date" cqlkey:"clustering,2"`
 	EndDate       string `json:"end_date" cql:"end_date"`
 	CurrencyType  string    `json:"currency_type" cql:"currency_type"`
 }
 
+func (BilledHistories) TableName() string {
+	return BILLEDHISTORIESBUCKET
+}
+
 func (bt *BilledHistoriesOpts) String() string {
 	if d, err := yaml.Marshal(bt); err != nil {
 		return err.Error()
@@ -42,6 +42,7 @@ const (
 	SCYLLAKEYSPACE = "scylla_keyspace"
 	SCYLLAUSERNAME = "scylla_username"
 	SCYLLAPASSWORD = "scylla_password"
+	SCYLLAEVENTTTL = "scylla_events_ttl"
 
 	ASSEMBLIES_ID = "assemblies_id"
 	EVENT_TYPE    = "event_type"